@@ -6,9 +6,12 @@ CLUSTER_NAME="ecommerce"
 
 echo "==> Building Docker images for all services"
 
+# Services build from ./services so that they can use sibling modules such as
+# the product client.
+
 for svc in "${SERVICES[@]}"; do
     echo "--- Building ecommerce/${svc}-service:latest"
-    docker build -t "ecommerce/${svc}-service:latest" "./services/${svc}"
+    docker build -t "ecommerce/${svc}-service:latest" -f "./services/${svc}/Dockerfile" ./services
 done
 
 echo "--- Building ecommerce/ui:latest"
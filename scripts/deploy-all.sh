@@ -12,6 +12,12 @@ kubectl apply -f k8s/postgres/
 echo "Waiting for PostgreSQL..."
 kubectl wait --namespace "${NAMESPACE}" --for=condition=ready pod -l app=postgres --timeout=120s
 
+# The init scripts only run on an empty data directory; re-apply the
+# idempotent schema so an existing database picks up new tables and columns.
+echo "Applying schema..."
+kubectl get configmap postgres-init --namespace "${NAMESPACE}" -o jsonpath='{.data.init\.sql}' |
+    kubectl exec -i --namespace "${NAMESPACE}" deploy/postgres -- psql -U ecommerce -d ecommerce -v ON_ERROR_STOP=1 -q
+
 echo "==> Deploying RabbitMQ"
 kubectl apply -f k8s/rabbitmq/
 echo "Waiting for RabbitMQ..."
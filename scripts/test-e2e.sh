@@ -70,13 +70,13 @@ echo "--- Product Catalog ---"
 PRODUCT1_RESP=$(check "Create product 1" "201" \
     -X POST "${BASE_URL}/api/products/" \
     -H "Content-Type: application/json" \
-    -d '{"name":"Laptop","description":"A powerful laptop","price":999.99,"stock":10}')
+    -d '{"name":"Laptop","description":"A powerful laptop","price":999.99,"stock":10,"status":"published"}')
 PRODUCT1_ID=$(echo "$PRODUCT1_RESP" | grep -o '"id":"[^"]*"' | head -1 | cut -d'"' -f4)
 
 PRODUCT2_RESP=$(check "Create product 2" "201" \
     -X POST "${BASE_URL}/api/products/" \
     -H "Content-Type: application/json" \
-    -d '{"name":"Mouse","description":"Wireless mouse","price":29.99,"stock":50}')
+    -d '{"name":"Mouse","description":"Wireless mouse","price":29.99,"stock":50,"status":"published"}')
 PRODUCT2_ID=$(echo "$PRODUCT2_RESP" | grep -o '"id":"[^"]*"' | head -1 | cut -d'"' -f4)
 
 check "List products" "200" "${BASE_URL}/api/products/" > /dev/null
@@ -88,18 +88,18 @@ echo "--- Shopping Cart ---"
 check "Add item to cart" "201" \
     -X POST "${BASE_URL}/api/cart/items" \
     -H "Content-Type: application/json" \
-    -H "X-User-ID: ${USER_ID}" \
+    -H "Authorization: Bearer ${TOKEN}" \
     -d "{\"product_id\":\"${PRODUCT1_ID}\",\"quantity\":1,\"price\":999.99}" > /dev/null
 
 check "Add second item" "201" \
     -X POST "${BASE_URL}/api/cart/items" \
     -H "Content-Type: application/json" \
-    -H "X-User-ID: ${USER_ID}" \
+    -H "Authorization: Bearer ${TOKEN}" \
     -d "{\"product_id\":\"${PRODUCT2_ID}\",\"quantity\":2,\"price\":29.99}" > /dev/null
 
 check "Get cart" "200" \
     "${BASE_URL}/api/cart/" \
-    -H "X-User-ID: ${USER_ID}" > /dev/null
+    -H "Authorization: Bearer ${TOKEN}" > /dev/null
 echo ""
 
 # 6. Create order
@@ -107,17 +107,18 @@ echo "--- Orders ---"
 ORDER_RESP=$(check "Create order" "201" \
     -X POST "${BASE_URL}/api/orders/" \
     -H "Content-Type: application/json" \
-    -H "X-User-ID: ${USER_ID}" \
-    -d "{\"items\":[{\"product_id\":\"${PRODUCT1_ID}\",\"quantity\":1,\"price\":999.99},{\"product_id\":\"${PRODUCT2_ID}\",\"quantity\":2,\"price\":29.99}]}")
+    -H "Authorization: Bearer ${TOKEN}" \
+    -d '{}')
 ORDER_ID=$(echo "$ORDER_RESP" | grep -o '"id":"[^"]*"' | head -1 | cut -d'"' -f4)
 echo "  Order ID: ${ORDER_ID}"
 
 check "List orders" "200" \
     "${BASE_URL}/api/orders/" \
-    -H "X-User-ID: ${USER_ID}" > /dev/null
+    -H "Authorization: Bearer ${TOKEN}" > /dev/null
 
 check "Get order" "200" \
-    "${BASE_URL}/api/orders/${ORDER_ID}" > /dev/null
+    "${BASE_URL}/api/orders/${ORDER_ID}" \
+    -H "Authorization: Bearer ${TOKEN}" > /dev/null
 echo ""
 
 # 7. Wait for payment processing
@@ -0,0 +1,5 @@
+module github.com/ecommerce/auth/identity
+
+go 1.22
+
+require github.com/golang-jwt/jwt/v5 v5.2.1
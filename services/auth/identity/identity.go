@@ -0,0 +1,60 @@
+// Package identity tells services who is calling, from the bearer token the
+// auth service issued.
+package identity
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Middleware sets X-User-ID and X-User-Role from the claims of the request's
+// bearer token. Clients can send those headers themselves and nothing in
+// front of the services strips them, so they are removed from every request
+// first: a caller without a token is anonymous, and one with an invalid token
+// is rejected.
+func Middleware(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del("X-User-ID")
+			r.Header.Del("X-User-Role")
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			raw, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				unauthorized(w, "invalid authorization format")
+				return
+			}
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+				return secret, nil
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+			if err != nil {
+				unauthorized(w, "invalid token")
+				return
+			}
+			userID, _ := claims["user_id"].(string)
+			role, _ := claims["role"].(string)
+			if userID == "" {
+				unauthorized(w, "invalid token claims")
+				return
+			}
+			r.Header.Set("X-User-ID", userID)
+			if role != "" {
+				r.Header.Set("X-User-Role", role)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,66 @@
+package identity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var secret = []byte("test-secret")
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return token
+}
+
+func TestMiddleware(t *testing.T) {
+	valid := jwt.MapClaims{"user_id": "user-1", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()}
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		userID        string
+		role          string
+	}{
+		{"no token", "", http.StatusOK, "", ""},
+		{"valid token", "Bearer " + sign(t, jwt.SigningMethodHS256, secret, valid), http.StatusOK, "user-1", "admin"},
+		{"no role claim", "Bearer " + sign(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"user_id": "user-2"}), http.StatusOK, "user-2", ""},
+		{"not bearer", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "", ""},
+		{"wrong secret", "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("other"), valid), http.StatusUnauthorized, "", ""},
+		{"wrong method", "Bearer " + sign(t, jwt.SigningMethodHS384, secret, valid), http.StatusUnauthorized, "", ""},
+		{"expired", "Bearer " + sign(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"user_id": "user-1", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized, "", ""},
+		{"no user", "Bearer " + sign(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"role": "admin"}), http.StatusUnauthorized, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userID, role string
+			handler := Middleware(secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID, role = r.Header.Get("X-User-ID"), r.Header.Get("X-User-Role")
+			}))
+
+			// Headers the client sets itself must never get through
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User-ID", "spoofed")
+			req.Header.Set("X-User-Role", "admin")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if userID != tt.userID || role != tt.role {
+				t.Errorf("X-User-ID = %q, X-User-Role = %q, want %q and %q", userID, role, tt.userID, tt.role)
+			}
+		})
+	}
+}
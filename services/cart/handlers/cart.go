@@ -1,132 +1,323 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ecommerce/cart/models"
+	"github.com/ecommerce/cart/pricing"
+	"github.com/ecommerce/product/client"
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
 )
 
+const itemColumns = `id, cart_id, product_id, quantity, price, currency, created_at, updated_at`
+
+// itemID matches cart item IDs, so that malformed IDs are not found rather
+// than rejected by Postgres.
+var itemID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
 type CartHandler struct {
-	DB *sqlx.DB
+	DB              *sqlx.DB
+	Products        *client.Client
+	Pricing         *pricing.Engine
+	DefaultCurrency string
+	// MaxQuantity caps the quantity of any one item in a cart.
+	MaxQuantity int
+	// Guest carts are identified by a cart token cookie signed with
+	// TokenSecret, and expire GuestTTL after they were last used.
+	TokenSecret []byte
+	GuestTTL    time.Duration
+	// MergeStrategy resolves items in both a guest cart and the user cart
+	// it merges into: sum or latest.
+	MergeStrategy string
 }
 
-func NewCartHandler(db *sqlx.DB) *CartHandler {
-	return &CartHandler{DB: db}
+// MinTokenSecretLength is the shortest CART_TOKEN_SECRET the service starts
+// with. Anyone who knows the secret can forge a token for any guest cart.
+const MinTokenSecretLength = 32
+
+func NewCartHandler(db *sqlx.DB, products *client.Client, engine *pricing.Engine) *CartHandler {
+	currency := "USD"
+	if v := os.Getenv("DEFAULT_CURRENCY"); len(v) == 3 {
+		currency = strings.ToUpper(v)
+	}
+	maxQuantity := 99
+	if v, err := strconv.Atoi(os.Getenv("MAX_ITEM_QUANTITY")); err == nil && v > 0 {
+		maxQuantity = v
+	}
+	guestTTL := 30 * 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL")); err == nil && v > 0 {
+		guestTTL = v
+	}
+	mergeStrategy := models.MergeSum
+	if v := os.Getenv("CART_MERGE_STRATEGY"); v == models.MergeLatest {
+		mergeStrategy = v
+	}
+	return &CartHandler{
+		DB:              db,
+		Products:        products,
+		Pricing:         engine,
+		DefaultCurrency: currency,
+		MaxQuantity:     maxQuantity,
+		TokenSecret:     []byte(os.Getenv("CART_TOKEN_SECRET")),
+		GuestTTL:        guestTTL,
+		MergeStrategy:   mergeStrategy,
+	}
 }
 
 func (h *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	if userID == "" {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing user ID"})
+	ref, ok := h.resolveCart(w, r, true)
+	if !ok {
 		return
 	}
 
-	// Get or create cart
+	cart, err := h.loadCart(r.Context(), ref.ID, customerRole(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch cart"})
+		return
+	}
+	cart.Merged = ref.Merged
+	writeJSON(w, http.StatusOK, cart)
+}
+
+// loadCart reads a cart and prices it against the product service and the
+// coupons applied to it.
+func (h *CartHandler) loadCart(ctx context.Context, cartID, role string) (*models.Cart, error) {
 	var cart models.Cart
 	err := h.DB.QueryRowx(
-		`SELECT id, user_id, created_at, updated_at FROM cart.carts WHERE user_id = $1`, userID,
+		`SELECT id, user_id, expires_at, created_at, updated_at FROM cart.carts WHERE id = $1`, cartID,
 	).StructScan(&cart)
 	if err != nil {
-		// Create new cart
-		err = h.DB.QueryRowx(
-			`INSERT INTO cart.carts (user_id) VALUES ($1) RETURNING id, user_id, created_at, updated_at`,
-			userID,
-		).StructScan(&cart)
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create cart"})
-			return
-		}
+		return nil, err
 	}
 
 	// Get cart items
 	var items []models.CartItem
 	err = h.DB.Select(&items,
-		`SELECT id, cart_id, product_id, quantity, price, created_at FROM cart.cart_items WHERE cart_id = $1`,
+		`SELECT `+itemColumns+` FROM cart.cart_items WHERE cart_id = $1 ORDER BY created_at`,
 		cart.ID,
 	)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch cart items"})
-		return
+		return nil, err
 	}
 	if items == nil {
 		items = []models.CartItem{}
 	}
 	cart.Items = items
-
-	writeJSON(w, http.StatusOK, cart)
+	h.reprice(ctx, &cart)
+	if err := h.price(ctx, &cart, role); err != nil {
+		return nil, err
+	}
+	return &cart, nil
 }
 
 func (h *CartHandler) AddItem(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	if userID == "" {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing user ID"})
-		return
-	}
-
 	var req models.AddItemRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
 		return
 	}
-	if req.ProductID == "" || req.Quantity <= 0 || req.Price <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "product_id, positive quantity and price are required"})
+	if req.ProductID == "" || req.Quantity <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "product_id and positive quantity are required"})
+		return
+	}
+	if req.Quantity > h.MaxQuantity {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("quantity must be at most %d", h.MaxQuantity)})
+		return
+	}
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.Currency == "" {
+		req.Currency = h.DefaultCurrency
+	}
+	if len(req.Currency) != 3 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be an ISO 4217 code"})
 		return
 	}
 
-	// Get or create cart
-	var cartID string
-	err := h.DB.QueryRow(`SELECT id FROM cart.carts WHERE user_id = $1`, userID).Scan(&cartID)
+	ref, ok := h.resolveCart(w, r, true)
+	if !ok {
+		return
+	}
+	cartID := ref.ID
+
+	// A cart holds a single currency
+	var otherCurrency bool
+	err := h.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM cart.cart_items WHERE cart_id = $1 AND currency <> $2)`, cartID, req.Currency,
+	).Scan(&otherCurrency)
 	if err != nil {
-		err = h.DB.QueryRow(
-			`INSERT INTO cart.carts (user_id) VALUES ($1) RETURNING id`, userID,
-		).Scan(&cartID)
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create cart"})
-			return
-		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check cart currency"})
+		return
+	}
+	if otherCurrency {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "cart already contains items in another currency"})
+		return
 	}
 
-	// Check if item already in cart, update quantity
+	// Check if item already in cart
 	var existingID string
+	var existingQuantity int
+	var existingPrice float64
 	err = h.DB.QueryRow(
-		`SELECT id FROM cart.cart_items WHERE cart_id = $1 AND product_id = $2`, cartID, req.ProductID,
-	).Scan(&existingID)
-	if err == nil {
-		// Update quantity
-		var item models.CartItem
+		`SELECT id, quantity, price FROM cart.cart_items WHERE cart_id = $1 AND product_id = $2`, cartID, req.ProductID,
+	).Scan(&existingID, &existingQuantity, &existingPrice)
+	if err != nil && err != sql.ErrNoRows {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check cart items"})
+		return
+	}
+
+	// Price and stock come from the product service, never the client
+	product, err := h.lookupProduct(r.Context(), req.ProductID, req.Currency)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	quantity := existingQuantity + req.Quantity
+	if quantity > h.MaxQuantity {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":        "cart holds too many of this item",
+			"product_id":   product.ID,
+			"requested":    quantity,
+			"max_quantity": h.MaxQuantity,
+		})
+		return
+	}
+	if product.Available < quantity {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":      "insufficient stock",
+			"product_id": product.ID,
+			"requested":  quantity,
+			"available":  product.Available,
+		})
+		return
+	}
+
+	var item models.CartItem
+	if existingID != "" {
 		err = h.DB.QueryRowx(
-			`UPDATE cart.cart_items SET quantity = quantity + $1 WHERE id = $2 
-			 RETURNING id, cart_id, product_id, quantity, price, created_at`,
-			req.Quantity, existingID,
+			`UPDATE cart.cart_items SET quantity = $1, price = $2, updated_at = NOW() WHERE id = $3
+			 RETURNING `+itemColumns,
+			quantity, product.Price, existingID,
 		).StructScan(&item)
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update item"})
 			return
 		}
+		item.Name = product.Name
+		item.Available = &product.Available
+		if !samePrice(existingPrice, product.Price) {
+			item.PreviousPrice = &existingPrice
+			item.Status = models.ItemPriceChanged
+		}
 		writeJSON(w, http.StatusOK, item)
 		return
 	}
 
 	// Insert new item
-	var item models.CartItem
 	err = h.DB.QueryRowx(
-		`INSERT INTO cart.cart_items (cart_id, product_id, quantity, price) VALUES ($1, $2, $3, $4)
-		 RETURNING id, cart_id, product_id, quantity, price, created_at`,
-		cartID, req.ProductID, req.Quantity, req.Price,
+		`INSERT INTO cart.cart_items (cart_id, product_id, quantity, price, currency) VALUES ($1, $2, $3, $4, $5)
+		 RETURNING `+itemColumns,
+		cartID, req.ProductID, req.Quantity, product.Price, req.Currency,
 	).StructScan(&item)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to add item"})
 		return
 	}
+	item.Name = product.Name
+	item.Available = &product.Available
 	writeJSON(w, http.StatusCreated, item)
 }
 
+// UpdateItem sets an item's quantity. Zero removes the item. Raising the
+// quantity is checked against stock; lowering it never is, so shoppers can
+// always trim an item the product service reports as short.
+func (h *CartHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Quantity == nil || *req.Quantity < 0 || *req.Quantity > h.MaxQuantity {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("quantity must be between 0 and %d", h.MaxQuantity)})
+		return
+	}
+
+	ref, ok := h.resolveCart(w, r, false)
+	if !ok {
+		return
+	}
+	item, err := h.ownedItem(ref.ID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch item"})
+		return
+	}
+	if item == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "item not found"})
+		return
+	}
+	if *req.Quantity == 0 {
+		h.removeItem(w, ref.ID, item.ID)
+		return
+	}
+
+	product, err := h.lookupProduct(r.Context(), item.ProductID, item.Currency)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	if *req.Quantity > item.Quantity && product.Available < *req.Quantity {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":      "insufficient stock",
+			"product_id": product.ID,
+			"requested":  *req.Quantity,
+			"available":  product.Available,
+		})
+		return
+	}
+
+	previous := item.Price
+	var updated models.CartItem
+	err = h.DB.QueryRowx(
+		`UPDATE cart.cart_items SET quantity = $1, price = $2, updated_at = NOW() WHERE id = $3
+		 RETURNING `+itemColumns,
+		*req.Quantity, product.Price, item.ID,
+	).StructScan(&updated)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update item"})
+		return
+	}
+	updated.Name = product.Name
+	updated.Available = &product.Available
+	if !samePrice(previous, product.Price) {
+		updated.PreviousPrice = &previous
+		updated.Status = models.ItemPriceChanged
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
 func (h *CartHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
-	itemID := chi.URLParam(r, "id")
-	result, err := h.DB.Exec(`DELETE FROM cart.cart_items WHERE id = $1`, itemID)
+	ref, ok := h.resolveCart(w, r, false)
+	if !ok {
+		return
+	}
+	h.removeItem(w, ref.ID, chi.URLParam(r, "id"))
+}
+
+func (h *CartHandler) removeItem(w http.ResponseWriter, cartID, id string) {
+	if !itemID.MatchString(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "item not found"})
+		return
+	}
+	result, err := h.DB.Exec(`DELETE FROM cart.cart_items WHERE id = $1 AND cart_id = $2`, id, cartID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to remove item"})
 		return
@@ -139,17 +330,47 @@ func (h *CartHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"message": "item removed"})
 }
 
+// cartID returns the ID of the user's cart, creating the cart if needed.
+func (h *CartHandler) cartID(userID string) (string, error) {
+	var cartID string
+	err := h.DB.QueryRow(`SELECT id FROM cart.carts WHERE user_id = $1`, userID).Scan(&cartID)
+	if err != nil {
+		err = h.DB.QueryRow(
+			`INSERT INTO cart.carts (user_id) VALUES ($1) RETURNING id`, userID,
+		).Scan(&cartID)
+	}
+	return cartID, err
+}
+
+// ownedItem loads an item from the caller's cart. Items in other carts are
+// reported as missing, so callers cannot tell them from IDs that do not exist.
+func (h *CartHandler) ownedItem(cartID, id string) (*models.CartItem, error) {
+	if !itemID.MatchString(id) {
+		return nil, nil
+	}
+	var item models.CartItem
+	err := h.DB.QueryRowx(
+		`SELECT `+itemColumns+` FROM cart.cart_items WHERE id = $1 AND cart_id = $2`, id, cartID,
+	).StructScan(&item)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
 func (h *CartHandler) ClearCart(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-ID")
-	if userID == "" {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing user ID"})
+	ref, ok := h.resolveCart(w, r, false)
+	if !ok {
 		return
 	}
 
-	_, err := h.DB.Exec(
-		`DELETE FROM cart.cart_items WHERE cart_id IN (SELECT id FROM cart.carts WHERE user_id = $1)`,
-		userID,
-	)
+	_, err := h.DB.Exec(`DELETE FROM cart.cart_items WHERE cart_id = $1`, ref.ID)
+	if err == nil {
+		_, err = h.DB.Exec(`DELETE FROM cart.cart_coupons WHERE cart_id = $1`, ref.ID)
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to clear cart"})
 		return
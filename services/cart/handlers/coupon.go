@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ecommerce/cart/models"
+	"github.com/ecommerce/cart/pricing"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// promotionColumns reads a cart.promotions row aliased as p, with its
+// redemption count and whether it is inside its validity window by the
+// database clock.
+const promotionColumns = `p.id, p.code, p.description, p.kind, p.value, p.currency, p.buy_quantity,
+	p.get_quantity, p.min_subtotal, p.categories, p.product_ids, p.first_order_only, p.roles,
+	p.max_redemptions, p.per_customer_limit, p.starts_at, p.ends_at, p.created_at,
+	(SELECT COUNT(*) FROM cart.coupon_redemptions cr WHERE cr.promotion_id = p.id) AS redemptions,
+	p.starts_at <= NOW() AS started, COALESCE(p.ends_at <= NOW(), FALSE) AS expired`
+
+var couponCode = regexp.MustCompile(`^[A-Z0-9_-]{1,64}$`)
+
+// customerRole is the caller's role from their auth token (see identity),
+// customer for signed-in callers without one, or guest for callers who are
+// not signed in.
+func customerRole(r *http.Request) string {
+	if r.Header.Get("X-User-ID") == "" {
+		return "guest"
+	}
+	if role := r.Header.Get("X-User-Role"); role != "" {
+		return role
+	}
+	return "customer"
+}
+
+// ApplyCoupon adds a coupon code to the caller's cart. Codes the caller is not
+// entitled to, or that give no discount on the cart as it stands, are
+// rejected with the reason.
+func (h *CartHandler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	var req models.ApplyCouponRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "code is required"})
+		return
+	}
+
+	var promotion models.Promotion
+	err := h.DB.QueryRowx(`SELECT `+promotionColumns+` FROM cart.promotions p WHERE p.code = $1`, code).StructScan(&promotion)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "coupon not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch coupon"})
+		return
+	}
+	ref, ok := h.resolveCart(w, r, true)
+	if !ok {
+		return
+	}
+	reason, err := h.entitlement(promotion, ref.UserID, customerRole(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check coupon"})
+		return
+	}
+	if reason != "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": reason})
+		return
+	}
+
+	cartID := ref.ID
+	_, err = h.DB.Exec(
+		`INSERT INTO cart.cart_coupons (cart_id, promotion_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		cartID, promotion.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to apply coupon"})
+		return
+	}
+
+	cart, err := h.loadCart(r.Context(), cartID, customerRole(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch cart"})
+		return
+	}
+	cart.Merged = ref.Merged
+	for _, c := range cart.Coupons {
+		if c.PromotionID == promotion.ID && !c.Applied {
+			h.DB.Exec(`DELETE FROM cart.cart_coupons WHERE cart_id = $1 AND promotion_id = $2`, cartID, promotion.ID)
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "coupon does not apply: " + c.Reason})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+// RemoveCoupon takes a coupon code off the caller's cart.
+func (h *CartHandler) RemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	ref, ok := h.resolveCart(w, r, false)
+	if !ok {
+		return
+	}
+
+	result, err := h.DB.Exec(
+		`DELETE FROM cart.cart_coupons cc USING cart.promotions p
+		 WHERE cc.promotion_id = p.id AND cc.cart_id = $1 AND p.code = $2`,
+		ref.ID, strings.ToUpper(chi.URLParam(r, "code")))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to remove coupon"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "coupon not applied to cart"})
+		return
+	}
+
+	cart, err := h.loadCart(r.Context(), ref.ID, customerRole(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch cart"})
+		return
+	}
+	cart.Merged = ref.Merged
+	writeJSON(w, http.StatusOK, cart)
+}
+
+// cartPromotions loads the promotions applied to a cart, oldest first.
+func (h *CartHandler) cartPromotions(cartID string) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+	err := h.DB.Select(&promotions,
+		`SELECT `+promotionColumns+` FROM cart.cart_coupons cc
+		 JOIN cart.promotions p ON p.id = cc.promotion_id
+		 WHERE cc.cart_id = $1 ORDER BY cc.applied_at`, cartID)
+	return promotions, err
+}
+
+// entitlement checks whether the user may use a promotion now: its validity
+// window, the roles it is limited to, its usage limits and whether it is only
+// for first orders. It returns the reason the user may not, or "" if they may.
+// The limits are checked again, under lock, when the order is placed. Guests,
+// with no user ID, cannot use coupons limited per customer or to first orders.
+func (h *CartHandler) entitlement(p models.Promotion, userID, role string) (string, error) {
+	switch {
+	case userID == "" && (p.PerCustomerLimit != nil || p.FirstOrderOnly):
+		return "sign in to use this coupon", nil
+	case !p.Started:
+		return "coupon is not active yet", nil
+	case p.Expired:
+		return "coupon has expired", nil
+	case len(p.Roles) > 0 && !contains(p.Roles, role):
+		return "coupon is not available for your account", nil
+	case p.MaxRedemptions != nil && p.Redemptions >= *p.MaxRedemptions:
+		return "coupon has been fully redeemed", nil
+	}
+	if p.PerCustomerLimit != nil {
+		var used int
+		err := h.DB.QueryRow(
+			`SELECT COUNT(*) FROM cart.coupon_redemptions WHERE promotion_id = $1 AND user_id = $2`,
+			p.ID, userID).Scan(&used)
+		if err != nil {
+			return "", err
+		}
+		if used >= *p.PerCustomerLimit {
+			return "you have already used this coupon", nil
+		}
+	}
+	if p.FirstOrderOnly {
+		var ordered bool
+		err := h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM orders.orders WHERE user_id = $1)`, userID).Scan(&ordered)
+		if err != nil {
+			return "", err
+		}
+		if ordered {
+			return "coupon is only valid on a first order", nil
+		}
+	}
+	return "", nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// PromotionHandler manages promotions. Its endpoints are for admins.
+type PromotionHandler struct {
+	DB *sqlx.DB
+}
+
+func NewPromotionHandler(db *sqlx.DB) *PromotionHandler {
+	return &PromotionHandler{DB: db}
+}
+
+// admin reports whether the caller's auth token carries the admin role,
+// answering 403 if not.
+func admin(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("X-User-Role") != "admin" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin role required"})
+		return false
+	}
+	return true
+}
+
+func (h *PromotionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !admin(w, r) {
+		return
+	}
+	var req models.CreatePromotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	if req.Currency != nil {
+		currency := strings.ToUpper(*req.Currency)
+		req.Currency = &currency
+	}
+	if req.Kind == pricing.PromotionBuyXGetY && req.Value == 0 {
+		req.Value = 100
+	}
+	if req.Description == "" {
+		req.Description = "Coupon " + req.Code
+	}
+	if msg := validatePromotion(req); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	var promotion models.Promotion
+	err := h.DB.QueryRowx(
+		`WITH p AS (
+			INSERT INTO cart.promotions (code, description, kind, value, currency, buy_quantity, get_quantity,
+				min_subtotal, categories, product_ids, first_order_only, roles, max_redemptions,
+				per_customer_limit, starts_at, ends_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, COALESCE($15, NOW()), $16)
+			RETURNING *
+		 ) SELECT `+promotionColumns+` FROM p`,
+		req.Code, req.Description, req.Kind, req.Value, req.Currency, req.BuyQuantity, req.GetQuantity,
+		req.MinSubtotal, pq.Array(nonNil(req.Categories)), pq.Array(nonNil(req.ProductIDs)), req.FirstOrderOnly,
+		pq.Array(nonNil(req.Roles)), req.MaxRedemptions, req.PerCustomerLimit, req.StartsAt, req.EndsAt,
+	).StructScan(&promotion)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "coupon code already exists"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create promotion"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, promotion)
+}
+
+func (h *PromotionHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !admin(w, r) {
+		return
+	}
+	var promotions []models.Promotion
+	if err := h.DB.Select(&promotions, `SELECT `+promotionColumns+` FROM cart.promotions p ORDER BY p.created_at DESC`); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch promotions"})
+		return
+	}
+	if promotions == nil {
+		promotions = []models.Promotion{}
+	}
+	writeJSON(w, http.StatusOK, promotions)
+}
+
+func validatePromotion(req models.CreatePromotionRequest) string {
+	switch {
+	case !couponCode.MatchString(req.Code):
+		return "code must be 1 to 64 letters, digits, dashes or underscores"
+	case req.Currency != nil && len(*req.Currency) != 3:
+		return "currency must be an ISO 4217 code"
+	case req.MinSubtotal < 0:
+		return "min_subtotal must not be negative"
+	case req.MinSubtotal > 0 && req.Currency == nil:
+		return "min_subtotal requires a currency"
+	case req.MaxRedemptions != nil && *req.MaxRedemptions <= 0,
+		req.PerCustomerLimit != nil && *req.PerCustomerLimit <= 0:
+		return "usage limits must be positive"
+	case req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt):
+		return "ends_at must be after starts_at"
+	}
+	for _, id := range req.ProductIDs {
+		if !itemID.MatchString(id) {
+			return "product_ids must be UUIDs"
+		}
+	}
+	switch req.Kind {
+	case pricing.PromotionPercentage:
+		if req.Value <= 0 || req.Value > 100 {
+			return "percentage value must be between 0 and 100"
+		}
+	case pricing.PromotionFixed:
+		if req.Value <= 0 || req.Currency == nil {
+			return "fixed promotions need a positive value and a currency"
+		}
+	case pricing.PromotionBuyXGetY:
+		if req.BuyQuantity <= 0 || req.GetQuantity <= 0 || req.Value <= 0 || req.Value > 100 {
+			return fmt.Sprintf("%s promotions need positive buy_quantity and get_quantity and a value between 0 and 100", req.Kind)
+		}
+	case pricing.PromotionFreeShipping:
+	default:
+		return "kind must be percentage, fixed, buy_x_get_y or free_shipping"
+	}
+	return ""
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
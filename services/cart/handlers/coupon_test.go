@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ecommerce/cart/models"
+)
+
+func TestValidatePromotion(t *testing.T) {
+	usd, euro := "USD", "EURO"
+	zero, one := 0, 1
+	start := time.Now()
+	end := start.Add(-time.Hour)
+	tests := []struct {
+		name string
+		req  models.CreatePromotionRequest
+		want string
+	}{
+		{"percentage", models.CreatePromotionRequest{Code: "TEN-OFF", Kind: "percentage", Value: 10}, ""},
+		{"fixed", models.CreatePromotionRequest{Code: "FIVE", Kind: "fixed", Value: 5, Currency: &usd}, ""},
+		{"buy x get y", models.CreatePromotionRequest{Code: "BOGO", Kind: "buy_x_get_y", BuyQuantity: 1, GetQuantity: 1, Value: 100}, ""},
+		{"free shipping", models.CreatePromotionRequest{Code: "SHIP", Kind: "free_shipping", PerCustomerLimit: &one}, ""},
+		{"lowercase code", models.CreatePromotionRequest{Code: "ten", Kind: "percentage", Value: 10}, "code must be 1 to 64 letters, digits, dashes or underscores"},
+		{"bad currency", models.CreatePromotionRequest{Code: "FIVE", Kind: "fixed", Value: 5, Currency: &euro}, "currency must be an ISO 4217 code"},
+		{"negative minimum", models.CreatePromotionRequest{Code: "TEN", Kind: "percentage", Value: 10, MinSubtotal: -1}, "min_subtotal must not be negative"},
+		{"minimum without currency", models.CreatePromotionRequest{Code: "TEN", Kind: "percentage", Value: 10, MinSubtotal: 20}, "min_subtotal requires a currency"},
+		{"zero limit", models.CreatePromotionRequest{Code: "TEN", Kind: "percentage", Value: 10, MaxRedemptions: &zero}, "usage limits must be positive"},
+		{"ends before start", models.CreatePromotionRequest{Code: "TEN", Kind: "percentage", Value: 10, StartsAt: &start, EndsAt: &end}, "ends_at must be after starts_at"},
+		{"bad product id", models.CreatePromotionRequest{Code: "TEN", Kind: "percentage", Value: 10, ProductIDs: []string{"42"}}, "product_ids must be UUIDs"},
+		{"percentage over 100", models.CreatePromotionRequest{Code: "TEN", Kind: "percentage", Value: 101}, "percentage value must be between 0 and 100"},
+		{"fixed without currency", models.CreatePromotionRequest{Code: "FIVE", Kind: "fixed", Value: 5}, "fixed promotions need a positive value and a currency"},
+		{"buy x get y without quantities", models.CreatePromotionRequest{Code: "BOGO", Kind: "buy_x_get_y", Value: 100}, "buy_x_get_y promotions need positive buy_quantity and get_quantity and a value between 0 and 100"},
+		{"unknown kind", models.CreatePromotionRequest{Code: "TEN", Kind: "cashback", Value: 10}, "kind must be percentage, fixed, buy_x_get_y or free_shipping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validatePromotion(tt.req); got != tt.want {
+				t.Errorf("validatePromotion = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntitlement(t *testing.T) {
+	one := 1
+	h := &CartHandler{}
+	tests := []struct {
+		name   string
+		p      models.Promotion
+		userID string
+		role   string
+		want   string
+	}{
+		{"open to all", models.Promotion{Started: true}, "", "guest", ""},
+		{"per-customer limit for a guest", models.Promotion{Started: true, PerCustomerLimit: &one}, "", "guest", "sign in to use this coupon"},
+		{"first order for a guest", models.Promotion{Started: true, FirstOrderOnly: true}, "", "guest", "sign in to use this coupon"},
+		{"not started", models.Promotion{}, "user-1", "customer", "coupon is not active yet"},
+		{"expired", models.Promotion{Started: true, Expired: true}, "user-1", "customer", "coupon has expired"},
+		{"wrong role", models.Promotion{Started: true, Roles: []string{"vip"}}, "user-1", "customer", "coupon is not available for your account"},
+		{"matching role", models.Promotion{Started: true, Roles: []string{"VIP"}}, "user-1", "vip", ""},
+		{"fully redeemed", models.Promotion{Started: true, MaxRedemptions: &one, Redemptions: 1}, "user-1", "customer", "coupon has been fully redeemed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.entitlement(tt.p, tt.userID, tt.role)
+			if err != nil {
+				t.Fatalf("entitlement: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("entitlement = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomerRole(t *testing.T) {
+	tests := []struct {
+		userID, role, want string
+	}{
+		{"", "", "guest"},
+		{"", "admin", "guest"},
+		{"user-1", "", "customer"},
+		{"user-1", "vip", "vip"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.userID != "" {
+			r.Header.Set("X-User-ID", tt.userID)
+		}
+		if tt.role != "" {
+			r.Header.Set("X-User-Role", tt.role)
+		}
+		if got := customerRole(r); got != tt.want {
+			t.Errorf("customerRole(%q, %q) = %q, want %q", tt.userID, tt.role, got, tt.want)
+		}
+	}
+}
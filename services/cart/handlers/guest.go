@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ecommerce/cart/models"
+)
+
+// cartCookie holds a guest's cart token: the cart ID and its signature.
+const cartCookie = "cart_token"
+
+// cartRef identifies the cart a request acts on. UserID is empty for guest
+// carts.
+type cartRef struct {
+	ID     string
+	UserID string
+	Merged *models.MergeResult
+}
+
+// resolveCart finds the caller's cart. Signed-in callers get their own cart,
+// created if needed, and a guest cart still in their cookie is merged into
+// it. Guests get the cart their token names, whose expiry is pushed back; if
+// they have none and create is set, a new guest cart is issued. It writes
+// the error response itself and returns ok false when there is no cart.
+func (h *CartHandler) resolveCart(w http.ResponseWriter, r *http.Request, create bool) (*cartRef, bool) {
+	userID := r.Header.Get("X-User-ID")
+	guestID, err := h.guestCart(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch cart"})
+		return nil, false
+	}
+
+	if userID != "" {
+		cartID, err := h.cartID(userID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create cart"})
+			return nil, false
+		}
+		ref := &cartRef{ID: cartID, UserID: userID}
+		if guestID != "" {
+			if ref.Merged, err = h.merge(guestID, cartID); err != nil {
+				log.Printf("Failed to merge guest cart %s into %s: %v", guestID, cartID, err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to merge guest cart"})
+				return nil, false
+			}
+			h.clearCartCookie(w, r)
+		}
+		return ref, true
+	}
+
+	if guestID == "" {
+		if !create {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing user ID or cart token"})
+			return nil, false
+		}
+		err = h.DB.QueryRow(
+			`INSERT INTO cart.carts (expires_at) VALUES (NOW() + $1 * INTERVAL '1 second') RETURNING id`,
+			int64(h.GuestTTL.Seconds()),
+		).Scan(&guestID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create cart"})
+			return nil, false
+		}
+	}
+	h.setCartCookie(w, r, guestID)
+	return &cartRef{ID: guestID}, true
+}
+
+// guestCart returns the live guest cart named by the request's cart token,
+// pushing its expiry back, or "" if the token is missing, forged or names a
+// cart that has expired or been merged.
+func (h *CartHandler) guestCart(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(cartCookie)
+	if err != nil {
+		return "", nil
+	}
+	cartID, ok := h.verifyToken(cookie.Value)
+	if !ok {
+		return "", nil
+	}
+	err = h.DB.QueryRow(
+		`UPDATE cart.carts SET expires_at = NOW() + $2 * INTERVAL '1 second'
+		 WHERE id = $1 AND user_id IS NULL AND expires_at > NOW()
+		 RETURNING id`,
+		cartID, int64(h.GuestTTL.Seconds()),
+	).Scan(&cartID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return cartID, err
+}
+
+// signToken returns the cart token for a guest cart: its ID and an HMAC of
+// the ID, so tokens cannot be guessed from cart IDs.
+func (h *CartHandler) signToken(cartID string) string {
+	mac := hmac.New(sha256.New, h.TokenSecret)
+	mac.Write([]byte(cartID))
+	return cartID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *CartHandler) verifyToken(token string) (string, bool) {
+	cartID, _, ok := strings.Cut(token, ".")
+	if !ok || !itemID.MatchString(cartID) {
+		return "", false
+	}
+	return cartID, hmac.Equal([]byte(token), []byte(h.signToken(cartID)))
+}
+
+func (h *CartHandler) setCartCookie(w http.ResponseWriter, r *http.Request, cartID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartCookie,
+		Value:    h.signToken(cartID),
+		Path:     "/",
+		MaxAge:   int(h.GuestTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *CartHandler) clearCartCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// secureRequest reports whether the client connected over HTTPS, directly or
+// through the ingress.
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// merge moves a guest cart's items and coupons into a user's cart and
+// deletes the guest cart, in one transaction. Items already in the user's
+// cart are resolved by MergeStrategy. A user cart holds a single currency, so
+// guest items in another currency are dropped.
+func (h *CartHandler) merge(guestID, userCartID string) (*models.MergeResult, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock both carts, in ID order so concurrent merges cannot deadlock. The
+	// guest cart may already have been merged by a concurrent request.
+	var locked []string
+	err = tx.Select(&locked, `SELECT id FROM cart.carts WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, guestID, userCartID)
+	if err != nil {
+		return nil, err
+	}
+	if len(locked) < 2 {
+		return nil, nil
+	}
+
+	var guestItems, userItems []models.CartItem
+	if err := tx.Select(&guestItems, `SELECT `+itemColumns+` FROM cart.cart_items WHERE cart_id = $1 ORDER BY created_at`, guestID); err != nil {
+		return nil, err
+	}
+	if err := tx.Select(&userItems, `SELECT `+itemColumns+` FROM cart.cart_items WHERE cart_id = $1`, userCartID); err != nil {
+		return nil, err
+	}
+
+	result := &models.MergeResult{Strategy: h.MergeStrategy, Added: []string{}, Updated: []string{}, Dropped: []string{}}
+	currency := ""
+	existing := make(map[string]models.CartItem, len(userItems))
+	for _, item := range userItems {
+		existing[item.ProductID] = item
+		currency = item.Currency
+	}
+	for _, item := range guestItems {
+		if currency == "" {
+			currency = item.Currency
+		}
+		if item.Currency != currency {
+			result.Dropped = append(result.Dropped, item.ProductID)
+			continue
+		}
+		mine, ok := existing[item.ProductID]
+		if !ok {
+			_, err = tx.Exec(`UPDATE cart.cart_items SET cart_id = $1 WHERE id = $2`, userCartID, item.ID)
+			if err != nil {
+				return nil, err
+			}
+			result.Added = append(result.Added, item.ProductID)
+			continue
+		}
+
+		quantity := h.mergedQuantity(mine, item)
+		if quantity != mine.Quantity {
+			_, err = tx.Exec(`UPDATE cart.cart_items SET quantity = $1, updated_at = NOW() WHERE id = $2`, quantity, mine.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result.Updated = append(result.Updated, item.ProductID)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO cart.cart_coupons (cart_id, promotion_id, applied_at)
+		 SELECT $1, promotion_id, applied_at FROM cart.cart_coupons WHERE cart_id = $2
+		 ON CONFLICT DO NOTHING`, userCartID, guestID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM cart.carts WHERE id = $1`, guestID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mergedQuantity resolves a product in both carts by MergeStrategy: the most
+// recently updated quantity wins, or the two are added up to MaxQuantity.
+func (h *CartHandler) mergedQuantity(mine, guest models.CartItem) int {
+	if h.MergeStrategy == models.MergeLatest {
+		if guest.UpdatedAt.After(mine.UpdatedAt) {
+			return guest.Quantity
+		}
+		return mine.Quantity
+	}
+	if quantity := mine.Quantity + guest.Quantity; quantity < h.MaxQuantity {
+		return quantity
+	}
+	return h.MaxQuantity
+}
+
+// StartGuestCartReaper deletes expired guest carts, with their items and
+// coupons.
+func (h *CartHandler) StartGuestCartReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := h.DB.Exec(`DELETE FROM cart.carts WHERE user_id IS NULL AND expires_at <= NOW()`)
+		if err != nil {
+			log.Printf("Failed to delete expired guest carts: %v", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Deleted %d expired guest carts", n)
+		}
+	}
+}
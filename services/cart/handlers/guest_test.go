@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ecommerce/cart/models"
+)
+
+const guestCartID = "6f1c2a4e-8d3b-4f5a-9c7e-0b1d2e3f4a5b"
+
+func TestCartTokens(t *testing.T) {
+	h := &CartHandler{TokenSecret: []byte(strings.Repeat("s", MinTokenSecretLength))}
+	other := &CartHandler{TokenSecret: []byte(strings.Repeat("o", MinTokenSecretLength))}
+	token := h.signToken(guestCartID)
+
+	if id, ok := h.verifyToken(token); !ok || id != guestCartID {
+		t.Errorf("verifyToken(signed) = %q, %v, want %q, true", id, ok, guestCartID)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other secret", other.signToken(guestCartID)},
+		{"no signature", guestCartID},
+		{"empty signature", guestCartID + "."},
+		{"signature of another cart", "0b1d2e3f-8d3b-4f5a-9c7e-6f1c2a4e4a5b" + token[len(guestCartID):]},
+		{"not a cart ID", "42." + strings.SplitN(h.signToken("42"), ".", 2)[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := h.verifyToken(tt.token); ok {
+				t.Errorf("verifyToken accepted %q", tt.token)
+			}
+		})
+	}
+}
+
+func TestCartCookie(t *testing.T) {
+	h := &CartHandler{TokenSecret: []byte(strings.Repeat("s", MinTokenSecretLength)), GuestTTL: time.Hour}
+	tests := []struct {
+		name   string
+		tls    bool
+		proto  string
+		secure bool
+	}{
+		{"plain", false, "", false},
+		{"direct https", true, "", true},
+		{"https through the ingress", false, "https", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rec := httptest.NewRecorder()
+			h.setCartCookie(rec, r, guestCartID)
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("got %d cookies, want 1", len(cookies))
+			}
+			c := cookies[0]
+			if c.Name != cartCookie || c.Value != h.signToken(guestCartID) || c.MaxAge != 3600 || !c.HttpOnly || c.Secure != tt.secure {
+				t.Errorf("cookie = %+v", c)
+			}
+		})
+	}
+}
+
+func TestMergedQuantity(t *testing.T) {
+	now := time.Now()
+	older := models.CartItem{Quantity: 3, UpdatedAt: now.Add(-time.Hour)}
+	newer := models.CartItem{Quantity: 8, UpdatedAt: now}
+	tests := []struct {
+		name        string
+		strategy    string
+		mine, guest models.CartItem
+		want        int
+	}{
+		{"sum", models.MergeSum, older, newer, 11},
+		{"sum capped", models.MergeSum, newer, newer, 12},
+		{"latest guest", models.MergeLatest, older, newer, 8},
+		{"latest mine", models.MergeLatest, newer, older, 8},
+		{"latest tie keeps mine", models.MergeLatest, models.CartItem{Quantity: 2, UpdatedAt: now}, newer, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &CartHandler{MergeStrategy: tt.strategy, MaxQuantity: 12}
+			if got := h.mergedQuantity(tt.mine, tt.guest); got != tt.want {
+				t.Errorf("mergedQuantity = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/ecommerce/cart/models"
+	"github.com/ecommerce/cart/pricing"
+	"github.com/ecommerce/product/client"
+)
+
+// productBatchLimit is the most IDs the product service looks up per batch.
+const productBatchLimit = 200
+
+// lookupProducts fetches the current price and availability of products in
+// the cart's currency. Products that are deleted, archived or unpublished are
+// left out of the result.
+func (h *CartHandler) lookupProducts(ctx context.Context, ids []string, currency string) (map[string]client.Product, error) {
+	products := make(map[string]client.Product, len(ids))
+	for start := 0; start < len(ids); start += productBatchLimit {
+		end := start + productBatchLimit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		result, err := h.Products.Batch(ctx, ids[start:end], client.Options{Currency: currency})
+		if err != nil {
+			return nil, err
+		}
+		for id, p := range result.Products {
+			products[id] = p
+		}
+	}
+	return products, nil
+}
+
+// lookupProduct fetches one product the same way, uncached, so stock and price
+// checks never see a read-through cache entry. A product that cannot be bought
+// is reported as client.ErrNotFound.
+func (h *CartHandler) lookupProduct(ctx context.Context, id, currency string) (*client.Product, error) {
+	products, err := h.lookupProducts(ctx, []string{id}, currency)
+	if err != nil {
+		return nil, err
+	}
+	product, ok := products[id]
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	return &product, nil
+}
+
+// reprice brings the cart's items up to date with the product service. Items
+// whose price changed are repriced and keep the old price in PreviousPrice;
+// items that are gone or short of stock are flagged but left in the cart so
+// the shopper can see what happened. If the product service cannot be
+// reached the cart is returned as stored and marked stale.
+func (h *CartHandler) reprice(ctx context.Context, cart *models.Cart) {
+	if len(cart.Items) == 0 {
+		return
+	}
+	ids := make([]string, len(cart.Items))
+	for i, item := range cart.Items {
+		ids[i] = item.ProductID
+	}
+	products, err := h.lookupProducts(ctx, ids, cart.Items[0].Currency)
+	if err != nil {
+		log.Printf("Failed to look up cart products: %v", err)
+		cart.Stale = true
+		return
+	}
+
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		product, ok := products[item.ProductID]
+		if !ok {
+			item.Status = models.ItemUnavailable
+			cart.Unavailable = true
+			continue
+		}
+		item.Name, item.Category = product.Name, product.Category
+		available := product.Available
+		item.Available = &available
+		item.Status = models.ItemOK
+
+		if !samePrice(item.Price, product.Price) {
+			previous := item.Price
+			if _, err := h.DB.Exec(`UPDATE cart.cart_items SET price = $1 WHERE id = $2`, product.Price, item.ID); err != nil {
+				log.Printf("Failed to reprice cart item %s: %v", item.ID, err)
+			}
+			item.Price, item.PreviousPrice = product.Price, &previous
+			item.Status = models.ItemPriceChanged
+			cart.PriceChanged = true
+		}
+		switch {
+		case available <= 0:
+			item.Status = models.ItemOutOfStock
+			cart.Unavailable = true
+		case available < item.Quantity:
+			item.Status = models.ItemInsufficientStock
+			cart.Unavailable = true
+		}
+	}
+}
+
+// samePrice compares prices to the cent, as the cart stores them.
+func samePrice(a, b float64) bool {
+	return math.Round(a*100) == math.Round(b*100)
+}
+
+// writeProductError responds to a failed product lookup.
+func writeProductError(w http.ResponseWriter, err error) {
+	var apiErr *client.Error
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+	case errors.As(err, &apiErr) && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusTooManyRequests:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": apiErr.Message})
+	default:
+		log.Printf("Failed to look up product: %v", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "product service unavailable"})
+	}
+}
+
+// price totals the cart's items. Items that cannot be bought at all are left
+// out; items short of stock are priced at the quantity in the cart. Coupons
+// the shopper is not entitled to are reported without being priced.
+func (h *CartHandler) price(ctx context.Context, cart *models.Cart, role string) error {
+	currency := h.DefaultCurrency
+	if len(cart.Items) > 0 {
+		currency = cart.Items[0].Currency
+	}
+	lines := make([]pricing.Line, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		if item.Status == models.ItemUnavailable || item.Status == models.ItemOutOfStock {
+			continue
+		}
+		lines = append(lines, pricing.Line{
+			ProductID: item.ProductID,
+			Name:      item.Name,
+			Category:  item.Category,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Price,
+		})
+	}
+
+	promotions, err := h.cartPromotions(cart.ID)
+	if err != nil {
+		return err
+	}
+	userID := ""
+	if cart.UserID != nil {
+		userID = *cart.UserID
+	}
+	cart.Coupons = []pricing.PromotionResult{}
+	var offers []pricing.Promotion
+	for _, p := range promotions {
+		reason, err := h.entitlement(p, userID, role)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			cart.Coupons = append(cart.Coupons, pricing.PromotionResult{
+				PromotionID: p.ID, Code: p.Code, Description: p.Description, Reason: reason,
+			})
+			continue
+		}
+		offers = append(offers, p.Offer())
+	}
+
+	quote, err := h.Pricing.Price(ctx, currency, lines, offers...)
+	if err != nil {
+		log.Printf("Failed to price cart %s: %v", cart.ID, err)
+		return err
+	}
+	cart.Totals = quote
+	cart.Coupons = append(cart.Coupons, quote.Promotions...)
+	return nil
+}
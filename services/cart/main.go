@@ -5,8 +5,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/ecommerce/auth/identity"
 	"github.com/ecommerce/cart/handlers"
+	"github.com/ecommerce/cart/pricing"
+	"github.com/ecommerce/product/client"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jmoiron/sqlx"
@@ -21,6 +25,7 @@ func main() {
 	dbPass := getEnv("DB_PASSWORD", "ecommerce_pass")
 	dbName := getEnv("DB_NAME", "ecommerce")
 	port := getEnv("PORT", "8083")
+	productURL := getEnv("PRODUCT_SERVICE_URL", "http://product-service:8082")
 
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPass, dbName)
@@ -31,11 +36,24 @@ func main() {
 	}
 	defer db.Close()
 
-	cartHandler := handlers.NewCartHandler(db)
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatal("JWT_SECRET must be set")
+	}
+	if len(os.Getenv("CART_TOKEN_SECRET")) < handlers.MinTokenSecretLength {
+		log.Fatalf("CART_TOKEN_SECRET must be at least %d bytes", handlers.MinTokenSecretLength)
+	}
+
+	cartHandler := handlers.NewCartHandler(db, client.New(productURL), pricing.FromEnv())
+	promotionHandler := handlers.NewPromotionHandler(db)
+
+	// Delete expired guest carts
+	go cartHandler.StartGuestCartReaper(time.Hour)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(identity.Middleware([]byte(jwtSecret)))
 
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if err := db.Ping(); err != nil {
@@ -49,8 +67,13 @@ func main() {
 
 	r.Get("/", cartHandler.GetCart)
 	r.Post("/items", cartHandler.AddItem)
+	r.Patch("/items/{id}", cartHandler.UpdateItem)
 	r.Delete("/items/{id}", cartHandler.RemoveItem)
 	r.Delete("/", cartHandler.ClearCart)
+	r.Post("/coupons", cartHandler.ApplyCoupon)
+	r.Delete("/coupons/{code}", cartHandler.RemoveCoupon)
+	r.Post("/promotions", promotionHandler.Create)
+	r.Get("/promotions", promotionHandler.List)
 
 	log.Printf("Cart service starting on port %s", port)
 	log.Fatal(http.ListenAndServe(":"+port, r))
@@ -1,13 +1,46 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"github.com/ecommerce/cart/pricing"
+)
+
+// Item statuses reported when a cart is read.
+const (
+	ItemOK                = "ok"
+	ItemPriceChanged      = "price_changed"
+	ItemInsufficientStock = "insufficient_stock"
+	ItemOutOfStock        = "out_of_stock"
+	ItemUnavailable       = "unavailable"
+)
+
+// Cart is a user's cart, or a guest cart with no user that expires at
+// ExpiresAt unless used again.
 type Cart struct {
 	ID        string     `json:"id" db:"id"`
-	UserID    string     `json:"user_id" db:"user_id"`
+	UserID    *string    `json:"user_id" db:"user_id"`
 	Items     []CartItem `json:"items"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+
+	// PriceChanged is set when any item's price changed since it was last
+	// seen, and Unavailable when any item can no longer be bought as is.
+	// Stale is set when the product service could not be reached and the
+	// items carry the prices last seen rather than current ones.
+	PriceChanged bool `json:"price_changed"`
+	Unavailable  bool `json:"unavailable"`
+	Stale        bool `json:"stale,omitempty"`
+
+	// Totals prices the items that can be bought, with a breakdown of every
+	// discount, shipping charge and tax.
+	Totals *pricing.Quote `json:"totals,omitempty"`
+	// Coupons lists the coupons applied to the cart, with the discount each
+	// gave or the reason it gave none.
+	Coupons []pricing.PromotionResult `json:"coupons"`
+	// Merged is set on the response that merged a guest cart into this one.
+	Merged *MergeResult `json:"merged,omitempty"`
 }
 
 type CartItem struct {
@@ -16,11 +49,28 @@ type CartItem struct {
 	ProductID string    `json:"product_id" db:"product_id"`
 	Quantity  int       `json:"quantity" db:"quantity"`
 	Price     float64   `json:"price" db:"price"`
+	Currency  string    `json:"currency" db:"currency"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// Filled in from the product service. PreviousPrice is the price the
+	// item carried before it was repriced.
+	Name          string   `json:"name,omitempty" db:"-"`
+	Category      *string  `json:"category,omitempty" db:"-"`
+	Available     *int     `json:"available,omitempty" db:"-"`
+	PreviousPrice *float64 `json:"previous_price,omitempty" db:"-"`
+	Status        string   `json:"status,omitempty" db:"-"`
 }
 
+// AddItemRequest adds a product to the cart. The price is always taken from
+// the product service.
 type AddItemRequest struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	Currency  string `json:"currency,omitempty"`
+}
+
+// UpdateItemRequest sets an item's quantity; zero removes the item.
+type UpdateItemRequest struct {
+	Quantity *int `json:"quantity"`
 }
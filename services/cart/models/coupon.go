@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ecommerce/cart/pricing"
+	"github.com/lib/pq"
+)
+
+// Promotion is a coupon code and the rules for using it. Value is a
+// percentage for percentage and buy_x_get_y promotions and an amount in
+// Currency for fixed ones.
+type Promotion struct {
+	ID               string         `json:"id" db:"id"`
+	Code             string         `json:"code" db:"code"`
+	Description      string         `json:"description" db:"description"`
+	Kind             string         `json:"kind" db:"kind"`
+	Value            float64        `json:"value" db:"value"`
+	Currency         *string        `json:"currency,omitempty" db:"currency"`
+	BuyQuantity      int            `json:"buy_quantity,omitempty" db:"buy_quantity"`
+	GetQuantity      int            `json:"get_quantity,omitempty" db:"get_quantity"`
+	MinSubtotal      float64        `json:"min_subtotal,omitempty" db:"min_subtotal"`
+	Categories       pq.StringArray `json:"categories" db:"categories"`
+	ProductIDs       pq.StringArray `json:"product_ids" db:"product_ids"`
+	FirstOrderOnly   bool           `json:"first_order_only" db:"first_order_only"`
+	Roles            pq.StringArray `json:"roles" db:"roles"`
+	MaxRedemptions   *int           `json:"max_redemptions,omitempty" db:"max_redemptions"`
+	PerCustomerLimit *int           `json:"per_customer_limit,omitempty" db:"per_customer_limit"`
+	StartsAt         time.Time      `json:"starts_at" db:"starts_at"`
+	EndsAt           *time.Time     `json:"ends_at,omitempty" db:"ends_at"`
+	CreatedAt        time.Time      `json:"created_at" db:"created_at"`
+	Redemptions      int            `json:"redemptions" db:"redemptions"`
+	Started          bool           `json:"-" db:"started"`
+	Expired          bool           `json:"-" db:"expired"`
+}
+
+// Offer is the part of the promotion the pricing engine applies.
+func (p Promotion) Offer() pricing.Promotion {
+	offer := pricing.Promotion{
+		ID:          p.ID,
+		Code:        p.Code,
+		Description: p.Description,
+		Kind:        p.Kind,
+		Value:       p.Value,
+		BuyQuantity: p.BuyQuantity,
+		GetQuantity: p.GetQuantity,
+		MinSubtotal: p.MinSubtotal,
+		Categories:  p.Categories,
+		ProductIDs:  p.ProductIDs,
+	}
+	if p.Currency != nil {
+		offer.Currency = *p.Currency
+	}
+	return offer
+}
+
+type CreatePromotionRequest struct {
+	Code             string     `json:"code"`
+	Description      string     `json:"description"`
+	Kind             string     `json:"kind"`
+	Value            float64    `json:"value"`
+	Currency         *string    `json:"currency,omitempty"`
+	BuyQuantity      int        `json:"buy_quantity,omitempty"`
+	GetQuantity      int        `json:"get_quantity,omitempty"`
+	MinSubtotal      float64    `json:"min_subtotal,omitempty"`
+	Categories       []string   `json:"categories,omitempty"`
+	ProductIDs       []string   `json:"product_ids,omitempty"`
+	FirstOrderOnly   bool       `json:"first_order_only,omitempty"`
+	Roles            []string   `json:"roles,omitempty"`
+	MaxRedemptions   *int       `json:"max_redemptions,omitempty"`
+	PerCustomerLimit *int       `json:"per_customer_limit,omitempty"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+}
+
+// ApplyCouponRequest adds a coupon code to the caller's cart.
+type ApplyCouponRequest struct {
+	Code string `json:"code"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// Merge strategies for items in both a guest cart and the user's cart.
+const (
+	// MergeSum adds the quantities, up to the maximum per item.
+	MergeSum = "sum"
+	// MergeLatest keeps the quantity of whichever line changed last.
+	MergeLatest = "latest"
+)
+
+// MergeResult lists, by product ID, what merging a guest cart did: items
+// moved into the user's cart, items already there whose quantity was
+// resolved, and items dropped because they were priced in another currency.
+type MergeResult struct {
+	Strategy string   `json:"strategy"`
+	Added    []string `json:"added"`
+	Updated  []string `json:"updated"`
+	Dropped  []string `json:"dropped"`
+}
@@ -0,0 +1,92 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FlatShipping charges a flat rate per currency, waived once the discounted
+// merchandise reaches the currency's free-shipping threshold. Carts in a
+// currency without a rate get no shipping estimate.
+type FlatShipping struct {
+	Rates    map[string]float64
+	FreeOver map[string]float64
+}
+
+func (FlatShipping) Name() string { return "flat_shipping" }
+
+func (s FlatShipping) Apply(ctx context.Context, q *Quote) ([]Adjustment, error) {
+	rate, ok := s.Rates[q.Currency]
+	if !ok || len(q.Lines) == 0 {
+		return nil, nil
+	}
+	if threshold, ok := s.FreeOver[q.Currency]; ok && q.Taxable() >= threshold {
+		return nil, nil
+	}
+	return []Adjustment{{
+		Type:        AdjustmentShipping,
+		Source:      s.Name(),
+		Description: "Standard shipping",
+		Amount:      rate,
+	}}, nil
+}
+
+// PercentageTax estimates tax as a single rate on the discounted merchandise.
+// The actual tax is settled at checkout once the address is known.
+type PercentageTax struct {
+	Rate float64
+}
+
+func (PercentageTax) Name() string { return "percentage_tax" }
+
+func (t PercentageTax) Apply(ctx context.Context, q *Quote) ([]Adjustment, error) {
+	if t.Rate <= 0 {
+		return nil, nil
+	}
+	return []Adjustment{{
+		Type:        AdjustmentTax,
+		Source:      t.Name(),
+		Description: fmt.Sprintf("Estimated tax (%s%%)", strconv.FormatFloat(t.Rate*100, 'f', -1, 64)),
+		Amount:      q.Taxable() * t.Rate,
+	}}, nil
+}
+
+// FromEnv builds the engine configured by SHIPPING_RATES and
+// FREE_SHIPPING_OVER (comma-separated CUR=amount pairs, such as
+// USD=5.99,EUR=4.99) and TAX_RATE (a fraction, such as 0.08). Promotions go
+// first, then shipping, then free-shipping promotions, then tax.
+func FromEnv() *Engine {
+	shipping := FlatShipping{
+		Rates:    currencyAmounts("SHIPPING_RATES", "USD=5.99"),
+		FreeOver: currencyAmounts("FREE_SHIPPING_OVER", "USD=50"),
+	}
+	var tax PercentageTax
+	if v, err := strconv.ParseFloat(os.Getenv("TAX_RATE"), 64); err == nil && v >= 0 && v < 1 {
+		tax.Rate = v
+	}
+	return NewEngine(Promotions{}, shipping, FreeShipping{}, tax)
+}
+
+// currencyAmounts parses a CUR=amount list from the environment, skipping
+// malformed entries.
+func currencyAmounts(key, fallback string) map[string]float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		v = fallback
+	}
+	amounts := map[string]float64{}
+	for _, pair := range strings.Split(v, ",") {
+		currency, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		amount, err := strconv.ParseFloat(value, 64)
+		if !ok || len(currency) != 3 || err != nil || amount < 0 {
+			log.Printf("Ignoring malformed %s entry %q", key, pair)
+			continue
+		}
+		amounts[strings.ToUpper(currency)] = amount
+	}
+	return amounts
+}
@@ -0,0 +1,177 @@
+// Package pricing computes cart totals. An Engine prices the lines, then runs
+// its calculators in order, each adding adjustments such as discounts,
+// shipping or tax. Every adjustment is kept in the quote's breakdown, so the
+// totals can always be explained line by line. Checkout prices carts with the
+// same engine, so shoppers are charged what the cart showed.
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Adjustment types. Discounts lower the merchandise total and shipping
+// discounts the shipping charge; shipping and tax raise the total.
+const (
+	AdjustmentDiscount         = "discount"
+	AdjustmentShipping         = "shipping"
+	AdjustmentShippingDiscount = "shipping_discount"
+	AdjustmentTax              = "tax"
+)
+
+// Line is one cart item to price.
+type Line struct {
+	ProductID string
+	Name      string
+	Category  *string
+	Quantity  int
+	UnitPrice float64
+}
+
+// LineTotal is a priced line. Discount is the sum of the discounts applied to
+// this line alone.
+type LineTotal struct {
+	ProductID string  `json:"product_id"`
+	Name      string  `json:"name,omitempty"`
+	Category  *string `json:"category,omitempty"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Total     float64 `json:"total"`
+	Discount  float64 `json:"discount"`
+}
+
+// Adjustment is one entry in a quote's breakdown. Amount is always positive;
+// Type says which way it moves the total. ProductID is set for adjustments
+// that apply to a single line, and Code for ones a coupon brought.
+type Adjustment struct {
+	Type        string  `json:"type"`
+	Source      string  `json:"source"`
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+	ProductID   string  `json:"product_id,omitempty"`
+	Code        string  `json:"code,omitempty"`
+}
+
+// Quote is a priced cart. Total is Subtotal - Discount + Shipping + Tax, where
+// Shipping is net of shipping discounts.
+type Quote struct {
+	Currency    string       `json:"currency"`
+	Lines       []LineTotal  `json:"lines"`
+	Subtotal    float64      `json:"subtotal"`
+	Discount    float64      `json:"discount"`
+	Shipping    float64      `json:"shipping"`
+	Tax         float64      `json:"tax"`
+	Total       float64      `json:"total"`
+	Adjustments []Adjustment `json:"adjustments"`
+	// Promotions reports, for each promotion priced, whether it applied.
+	Promotions []PromotionResult `json:"-"`
+
+	promotions []Promotion
+	reasons    map[string]string
+}
+
+// Taxable is the amount tax is charged on: the discounted merchandise.
+func (q *Quote) Taxable() float64 {
+	return math.Max(0, q.Subtotal-q.Discount)
+}
+
+// Calculator adds adjustments to a quote. Calculators see the totals left by
+// the ones before them, so discounts run before shipping and tax.
+type Calculator interface {
+	Name() string
+	Apply(ctx context.Context, q *Quote) ([]Adjustment, error)
+}
+
+// Engine prices carts with a fixed pipeline of calculators.
+type Engine struct {
+	Calculators []Calculator
+}
+
+func NewEngine(calculators ...Calculator) *Engine {
+	return &Engine{Calculators: calculators}
+}
+
+// Price prices lines in currency and runs the calculators over the result.
+// The promotions are the ones the shopper is entitled to; calculators decide
+// whether each one applies to these lines.
+func (e *Engine) Price(ctx context.Context, currency string, lines []Line, promotions ...Promotion) (*Quote, error) {
+	q := &Quote{
+		Currency:    currency,
+		Lines:       make([]LineTotal, 0, len(lines)),
+		Adjustments: []Adjustment{},
+		promotions:  promotions,
+		reasons:     map[string]string{},
+	}
+	for _, l := range lines {
+		total := Round(l.UnitPrice * float64(l.Quantity))
+		q.Lines = append(q.Lines, LineTotal{
+			ProductID: l.ProductID,
+			Name:      l.Name,
+			Category:  l.Category,
+			Quantity:  l.Quantity,
+			UnitPrice: l.UnitPrice,
+			Total:     total,
+		})
+		q.Subtotal += total
+	}
+	q.Subtotal = Round(q.Subtotal)
+
+	for _, c := range e.Calculators {
+		adjustments, err := c.Apply(ctx, q)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name(), err)
+		}
+		for _, a := range adjustments {
+			q.add(a)
+		}
+	}
+	q.Total = Round(q.Subtotal - q.Discount + q.Shipping + q.Tax)
+	q.Promotions = q.promotionResults()
+	return q, nil
+}
+
+// add records an adjustment. Discounts are capped so that they never take the
+// merchandise, the line they apply to or the shipping charge below zero.
+func (q *Quote) add(a Adjustment) {
+	a.Amount = Round(a.Amount)
+	line := -1
+	for i := range q.Lines {
+		if a.ProductID != "" && q.Lines[i].ProductID == a.ProductID {
+			line = i
+		}
+	}
+	switch a.Type {
+	case AdjustmentDiscount:
+		a.Amount = math.Min(a.Amount, q.Taxable())
+		if line >= 0 {
+			a.Amount = math.Min(a.Amount, Round(q.Lines[line].Total-q.Lines[line].Discount))
+		}
+	case AdjustmentShippingDiscount:
+		a.Amount = math.Min(a.Amount, q.Shipping)
+	}
+	if a.Amount <= 0 {
+		return
+	}
+	switch a.Type {
+	case AdjustmentDiscount:
+		q.Discount = Round(q.Discount + a.Amount)
+		if line >= 0 {
+			q.Lines[line].Discount = Round(q.Lines[line].Discount + a.Amount)
+		}
+	case AdjustmentShipping:
+		q.Shipping = Round(q.Shipping + a.Amount)
+	case AdjustmentShippingDiscount:
+		q.Shipping = Round(q.Shipping - a.Amount)
+	case AdjustmentTax:
+		q.Tax = Round(q.Tax + a.Amount)
+	default:
+		return
+	}
+	q.Adjustments = append(q.Adjustments, a)
+}
+
+// Round rounds an amount to the cent.
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
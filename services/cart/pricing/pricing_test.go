@@ -0,0 +1,198 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func price(t *testing.T, e *Engine, lines []Line, promotions ...Promotion) *Quote {
+	t.Helper()
+	q, err := e.Price(context.Background(), "USD", lines, promotions...)
+	if err != nil {
+		t.Fatalf("Price: %v", err)
+	}
+	return q
+}
+
+// fixed adds the same adjustment to every quote.
+type fixed Adjustment
+
+func (fixed) Name() string { return "fixed" }
+
+func (f fixed) Apply(ctx context.Context, q *Quote) ([]Adjustment, error) {
+	return []Adjustment{Adjustment(f)}, nil
+}
+
+type failing struct{}
+
+func (failing) Name() string { return "failing" }
+
+func (failing) Apply(ctx context.Context, q *Quote) ([]Adjustment, error) {
+	return nil, errors.New("boom")
+}
+
+func TestPriceTotalsLines(t *testing.T) {
+	lines := []Line{
+		{ProductID: "a", Quantity: 3, UnitPrice: 0.1},
+		{ProductID: "b", Quantity: 2, UnitPrice: 19.99},
+	}
+	q := price(t, NewEngine(), lines)
+	if q.Lines[0].Total != 0.3 || q.Lines[1].Total != 39.98 {
+		t.Errorf("line totals = %v and %v, want 0.3 and 39.98", q.Lines[0].Total, q.Lines[1].Total)
+	}
+	if q.Subtotal != 40.28 || q.Total != 40.28 {
+		t.Errorf("Subtotal = %v, Total = %v, want 40.28", q.Subtotal, q.Total)
+	}
+	if q.Currency != "USD" || len(q.Adjustments) != 0 {
+		t.Errorf("quote = %+v, want USD with no adjustments", q)
+	}
+}
+
+func TestPriceWrapsCalculatorErrors(t *testing.T) {
+	_, err := NewEngine(failing{}).Price(context.Background(), "USD", []Line{{ProductID: "a", Quantity: 1, UnitPrice: 1}})
+	if err == nil || err.Error() != "failing: boom" {
+		t.Errorf("err = %v, want failing: boom", err)
+	}
+}
+
+func TestDiscountsAreCappedAtMerchandise(t *testing.T) {
+	lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 30}}
+	e := NewEngine(
+		fixed{Type: AdjustmentDiscount, Source: "fixed", Amount: 25},
+		fixed{Type: AdjustmentDiscount, Source: "fixed", Amount: 25},
+		FlatShipping{Rates: map[string]float64{"USD": 5}},
+	)
+	q := price(t, e, lines)
+	if q.Discount != 30 || q.Adjustments[1].Amount != 5 {
+		t.Errorf("Discount = %v, second discount = %v, want 30 and 5", q.Discount, q.Adjustments[1].Amount)
+	}
+	if q.Total != 5 {
+		t.Errorf("Total = %v, want shipping alone", q.Total)
+	}
+}
+
+func TestFlatShipping(t *testing.T) {
+	shipping := FlatShipping{
+		Rates:    map[string]float64{"USD": 5.99, "EUR": 4.99},
+		FreeOver: map[string]float64{"USD": 50},
+	}
+	tests := []struct {
+		name     string
+		currency string
+		lines    []Line
+		discount float64
+		want     float64
+	}{
+		{"below threshold", "USD", []Line{{ProductID: "a", Quantity: 1, UnitPrice: 49.99}}, 0, 5.99},
+		{"at threshold", "USD", []Line{{ProductID: "a", Quantity: 2, UnitPrice: 25}}, 0, 0},
+		// The threshold is measured after discounts.
+		{"discounted below threshold", "USD", []Line{{ProductID: "a", Quantity: 1, UnitPrice: 60}}, 15, 5.99},
+		{"no threshold", "EUR", []Line{{ProductID: "a", Quantity: 1, UnitPrice: 500}}, 0, 4.99},
+		{"no rate", "GBP", []Line{{ProductID: "a", Quantity: 1, UnitPrice: 10}}, 0, 0},
+		{"empty cart", "USD", nil, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine(fixed{Type: AdjustmentDiscount, Source: "fixed", Amount: tt.discount}, shipping)
+			q, err := e.Price(context.Background(), tt.currency, tt.lines)
+			if err != nil {
+				t.Fatalf("Price: %v", err)
+			}
+			if q.Shipping != tt.want {
+				t.Errorf("Shipping = %v, want %v", q.Shipping, tt.want)
+			}
+			if want := q.Subtotal - q.Discount + tt.want; q.Total != Round(want) {
+				t.Errorf("Total = %v, want %v", q.Total, Round(want))
+			}
+		})
+	}
+}
+
+func TestPercentageTax(t *testing.T) {
+	lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 55}}
+
+	t.Run("charged on discounted merchandise", func(t *testing.T) {
+		e := NewEngine(
+			fixed{Type: AdjustmentDiscount, Source: "fixed", Amount: 5.5},
+			FlatShipping{Rates: map[string]float64{"USD": 5}},
+			PercentageTax{Rate: 0.1},
+		)
+		q := price(t, e, lines)
+		// Tax is 10% of the 49.50 left after the discount, not of shipping.
+		if q.Tax != 4.95 {
+			t.Errorf("Tax = %v, want 4.95", q.Tax)
+		}
+		if q.Total != 59.45 {
+			t.Errorf("Total = %v, want 59.45", q.Total)
+		}
+		last := q.Adjustments[len(q.Adjustments)-1]
+		if last.Type != AdjustmentTax || last.Source != "percentage_tax" || last.Description != "Estimated tax (10%)" {
+			t.Errorf("tax adjustment = %+v", last)
+		}
+	})
+
+	t.Run("zero rate", func(t *testing.T) {
+		q := price(t, NewEngine(PercentageTax{}), lines)
+		if q.Tax != 0 || len(q.Adjustments) != 0 {
+			t.Errorf("Tax = %v with %d adjustments, want none", q.Tax, len(q.Adjustments))
+		}
+	})
+
+	t.Run("rounded to the cent", func(t *testing.T) {
+		q := price(t, NewEngine(PercentageTax{Rate: 0.0825}), []Line{{ProductID: "a", Quantity: 1, UnitPrice: 9.99}})
+		if q.Tax != 0.82 || q.Total != 10.81 {
+			t.Errorf("Tax = %v, Total = %v, want 0.82 and 10.81", q.Tax, q.Total)
+		}
+	})
+}
+
+func TestCurrencyAmounts(t *testing.T) {
+	t.Setenv("TEST_AMOUNTS", " usd=5.99, EUR=4.99,GBP,JPY=abc,CAD=-1,EURO=1,CHF=0")
+	want := map[string]float64{"USD": 5.99, "EUR": 4.99, "CHF": 0}
+	if got := currencyAmounts("TEST_AMOUNTS", "USD=1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("currencyAmounts = %v, want %v", got, want)
+	}
+
+	t.Setenv("TEST_AMOUNTS", "")
+	if got := currencyAmounts("TEST_AMOUNTS", "USD=1"); !reflect.DeepEqual(got, map[string]float64{"USD": 1}) {
+		t.Errorf("currencyAmounts = %v, want the fallback", got)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		t.Setenv("SHIPPING_RATES", "")
+		t.Setenv("FREE_SHIPPING_OVER", "")
+		t.Setenv("TAX_RATE", "")
+		q := price(t, FromEnv(), []Line{{ProductID: "a", Quantity: 1, UnitPrice: 20}})
+		if q.Shipping != 5.99 || q.Tax != 0 || q.Total != 25.99 {
+			t.Errorf("Shipping = %v, Tax = %v, Total = %v, want 5.99, 0 and 25.99", q.Shipping, q.Tax, q.Total)
+		}
+		q = price(t, FromEnv(), []Line{{ProductID: "a", Quantity: 1, UnitPrice: 50}})
+		if q.Shipping != 0 {
+			t.Errorf("Shipping = %v over the default threshold, want 0", q.Shipping)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("SHIPPING_RATES", "USD=7")
+		t.Setenv("FREE_SHIPPING_OVER", "USD=100")
+		t.Setenv("TAX_RATE", "0.08")
+		q := price(t, FromEnv(), []Line{{ProductID: "a", Quantity: 1, UnitPrice: 50}})
+		if q.Shipping != 7 || q.Tax != 4 || q.Total != 61 {
+			t.Errorf("Shipping = %v, Tax = %v, Total = %v, want 7, 4 and 61", q.Shipping, q.Tax, q.Total)
+		}
+	})
+
+	t.Run("invalid tax rate", func(t *testing.T) {
+		for _, rate := range []string{"8", "-0.1", "abc"} {
+			t.Setenv("TAX_RATE", rate)
+			q := price(t, FromEnv(), []Line{{ProductID: "a", Quantity: 1, UnitPrice: 50}})
+			if q.Tax != 0 {
+				t.Errorf("TAX_RATE=%s: Tax = %v, want 0", rate, q.Tax)
+			}
+		}
+	})
+}
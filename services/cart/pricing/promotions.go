@@ -0,0 +1,238 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Promotion kinds.
+const (
+	// PromotionPercentage takes Value percent off the eligible items.
+	PromotionPercentage = "percentage"
+	// PromotionFixed takes Value off the eligible items.
+	PromotionFixed = "fixed"
+	// PromotionBuyXGetY discounts GetQuantity of every BuyQuantity +
+	// GetQuantity eligible units by Value percent, cheapest units first.
+	PromotionBuyXGetY = "buy_x_get_y"
+	// PromotionFreeShipping waives the shipping charge.
+	PromotionFreeShipping = "free_shipping"
+)
+
+// Promotion is a coupon's offer and the cart conditions it needs. Who may
+// use it, how often and when are checked before it reaches the engine.
+// Fixed amounts and MinSubtotal are in Currency, and such promotions only
+// apply to carts in that currency. Categories and ProductIDs, when set, limit
+// the offer to matching items.
+type Promotion struct {
+	ID          string
+	Code        string
+	Description string
+	Kind        string
+	Value       float64
+	Currency    string
+	BuyQuantity int
+	GetQuantity int
+	MinSubtotal float64
+	Categories  []string
+	ProductIDs  []string
+}
+
+// PromotionResult says whether a promotion applied and, if not, why.
+type PromotionResult struct {
+	PromotionID string  `json:"promotion_id"`
+	Code        string  `json:"code"`
+	Description string  `json:"description"`
+	Applied     bool    `json:"applied"`
+	Amount      float64 `json:"amount"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+// Promotions applies percentage, fixed and buy-X-get-Y promotions. It runs
+// before shipping, so discounted carts are judged against the free-shipping
+// threshold on what the shopper actually pays.
+type Promotions struct{}
+
+func (Promotions) Name() string { return "promotions" }
+
+func (c Promotions) Apply(ctx context.Context, q *Quote) ([]Adjustment, error) {
+	// Promotions stack: each one sees the merchandise left after the ones
+	// before it.
+	var adjustments []Adjustment
+	taken := 0.0
+	lineTaken := map[int]float64{}
+	remaining := func(i int) float64 {
+		return q.Lines[i].Total - q.Lines[i].Discount - lineTaken[i]
+	}
+	for _, p := range q.promotions {
+		if p.Kind == PromotionFreeShipping {
+			continue
+		}
+		lines, reason := q.eligible(p)
+		if reason != "" {
+			q.reasons[p.Code] = reason
+			continue
+		}
+		discount := func(amount float64, line int) {
+			a := Adjustment{
+				Type:        AdjustmentDiscount,
+				Source:      c.Name(),
+				Description: p.Description,
+				Amount:      Round(amount),
+				Code:        p.Code,
+			}
+			if line >= 0 {
+				a.ProductID = q.Lines[line].ProductID
+				lineTaken[line] += a.Amount
+			}
+			taken += a.Amount
+			adjustments = append(adjustments, a)
+		}
+
+		switch p.Kind {
+		case PromotionPercentage:
+			if len(p.Categories) == 0 && len(p.ProductIDs) == 0 {
+				discount((q.Taxable()-taken)*p.Value/100, -1)
+				break
+			}
+			for _, i := range lines {
+				discount(remaining(i)*p.Value/100, i)
+			}
+		case PromotionFixed:
+			eligible := 0.0
+			for _, i := range lines {
+				eligible += remaining(i)
+			}
+			if eligible < p.Value {
+				discount(eligible, -1)
+			} else {
+				discount(p.Value, -1)
+			}
+		case PromotionBuyXGetY:
+			free := q.freeUnits(lines, p.BuyQuantity, p.GetQuantity)
+			if len(free) == 0 {
+				q.reasons[p.Code] = fmt.Sprintf("add %d eligible items to get %d discounted", p.BuyQuantity+p.GetQuantity, p.GetQuantity)
+			}
+			for _, i := range lines {
+				if free[i] > 0 {
+					discount(float64(free[i])*q.Lines[i].UnitPrice*p.Value/100, i)
+				}
+			}
+		}
+	}
+	return adjustments, nil
+}
+
+// FreeShipping waives shipping for free-shipping promotions. It runs after
+// shipping is charged and before tax.
+type FreeShipping struct{}
+
+func (FreeShipping) Name() string { return "free_shipping" }
+
+func (c FreeShipping) Apply(ctx context.Context, q *Quote) ([]Adjustment, error) {
+	var adjustments []Adjustment
+	for _, p := range q.promotions {
+		if p.Kind != PromotionFreeShipping {
+			continue
+		}
+		if _, reason := q.eligible(p); reason != "" {
+			q.reasons[p.Code] = reason
+			continue
+		}
+		if q.Shipping <= 0 || len(adjustments) > 0 {
+			q.reasons[p.Code] = "shipping is already free"
+			continue
+		}
+		adjustments = append(adjustments, Adjustment{
+			Type:        AdjustmentShippingDiscount,
+			Source:      c.Name(),
+			Description: p.Description,
+			Amount:      q.Shipping,
+			Code:        p.Code,
+		})
+	}
+	return adjustments, nil
+}
+
+// eligible returns the indexes of the lines a promotion covers, or the reason
+// it cannot apply to this quote.
+func (q *Quote) eligible(p Promotion) ([]int, string) {
+	if (p.Kind == PromotionFixed || p.MinSubtotal > 0) && p.Currency != "" && p.Currency != q.Currency {
+		return nil, "not valid for " + q.Currency + " carts"
+	}
+	if q.Subtotal < p.MinSubtotal {
+		return nil, fmt.Sprintf("requires a subtotal of at least %.2f %s", p.MinSubtotal, p.Currency)
+	}
+	var lines []int
+	for i, l := range q.Lines {
+		if p.covers(l) {
+			lines = append(lines, i)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, "no eligible items in the cart"
+	}
+	return lines, ""
+}
+
+func (p Promotion) covers(l LineTotal) bool {
+	if len(p.Categories) == 0 && len(p.ProductIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if strings.EqualFold(id, l.ProductID) {
+			return true
+		}
+	}
+	for _, category := range p.Categories {
+		if l.Category != nil && strings.EqualFold(category, *l.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeUnits counts, per line index, the units a buy-X-get-Y offer discounts:
+// get of every buy+get units across the lines, taking the cheapest units.
+func (q *Quote) freeUnits(lines []int, buy, get int) map[int]int {
+	units := make([]int, 0)
+	for _, i := range lines {
+		for n := 0; n < q.Lines[i].Quantity; n++ {
+			units = append(units, i)
+		}
+	}
+	free := map[int]int{}
+	if buy <= 0 || get <= 0 {
+		return free
+	}
+	sort.SliceStable(units, func(a, b int) bool {
+		return q.Lines[units[a]].UnitPrice < q.Lines[units[b]].UnitPrice
+	})
+	for _, i := range units[:len(units)/(buy+get)*get] {
+		free[i]++
+	}
+	return free
+}
+
+// promotionResults sums each promotion's adjustments.
+func (q *Quote) promotionResults() []PromotionResult {
+	results := make([]PromotionResult, 0, len(q.promotions))
+	for _, p := range q.promotions {
+		result := PromotionResult{PromotionID: p.ID, Code: p.Code, Description: p.Description}
+		for _, a := range q.Adjustments {
+			if a.Code == p.Code {
+				result.Amount = Round(result.Amount + a.Amount)
+			}
+		}
+		result.Applied = result.Amount > 0
+		if !result.Applied {
+			result.Reason = q.reasons[p.Code]
+			if result.Reason == "" {
+				result.Reason = "no discount applies to this cart"
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,192 @@
+package pricing
+
+import "testing"
+
+func lineDiscount(q *Quote, productID string) float64 {
+	for _, l := range q.Lines {
+		if l.ProductID == productID {
+			return l.Discount
+		}
+	}
+	return 0
+}
+
+func TestPromotionsStackInOrder(t *testing.T) {
+	lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 100}}
+	percent := Promotion{Code: "TENOFF", Kind: PromotionPercentage, Value: 10}
+	fixed := Promotion{Code: "TWENTY", Kind: PromotionFixed, Value: 20, Currency: "USD"}
+
+	tests := []struct {
+		name       string
+		promotions []Promotion
+		discount   float64
+		amounts    []float64
+	}{
+		// 10% of 100, then 20 off.
+		{"percentage first", []Promotion{percent, fixed}, 30, []float64{10, 20}},
+		// 20 off, then 10% of the 80 left.
+		{"fixed first", []Promotion{fixed, percent}, 28, []float64{20, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := price(t, NewEngine(Promotions{}), lines, tt.promotions...)
+			if q.Discount != tt.discount {
+				t.Errorf("Discount = %v, want %v", q.Discount, tt.discount)
+			}
+			if len(q.Adjustments) != len(tt.amounts) {
+				t.Fatalf("got %d adjustments, want %d", len(q.Adjustments), len(tt.amounts))
+			}
+			for i, a := range q.Adjustments {
+				if a.Code != tt.promotions[i].Code || a.Amount != tt.amounts[i] {
+					t.Errorf("adjustment %d = %s %v, want %s %v", i, a.Code, a.Amount, tt.promotions[i].Code, tt.amounts[i])
+				}
+			}
+			if q.Total != 100-tt.discount {
+				t.Errorf("Total = %v, want %v", q.Total, 100-tt.discount)
+			}
+		})
+	}
+}
+
+func TestPromotionsRunBeforeShippingAndTax(t *testing.T) {
+	e := NewEngine(
+		Promotions{},
+		FlatShipping{Rates: map[string]float64{"USD": 5}, FreeOver: map[string]float64{"USD": 50}},
+		FreeShipping{},
+		PercentageTax{Rate: 0.1},
+	)
+	lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 55}}
+	q := price(t, e, lines, Promotion{Code: "TENOFF", Kind: PromotionPercentage, Value: 10})
+
+	// The discount takes the cart below the free-shipping threshold, and tax
+	// is charged on what is left after it.
+	if q.Discount != 5.5 {
+		t.Errorf("Discount = %v, want 5.5", q.Discount)
+	}
+	if q.Shipping != 5 {
+		t.Errorf("Shipping = %v, want 5", q.Shipping)
+	}
+	if q.Tax != 4.95 {
+		t.Errorf("Tax = %v, want 4.95", q.Tax)
+	}
+	if q.Total != 59.45 {
+		t.Errorf("Total = %v, want 59.45", q.Total)
+	}
+}
+
+func TestDiscountsAreCapped(t *testing.T) {
+	t.Run("merchandise", func(t *testing.T) {
+		lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 30}}
+		q := price(t, NewEngine(Promotions{}), lines,
+			Promotion{Code: "HALF", Kind: PromotionPercentage, Value: 50},
+			Promotion{Code: "BIG", Kind: PromotionFixed, Value: 25, Currency: "USD"},
+		)
+		if q.Discount != 30 || q.Total != 0 {
+			t.Errorf("Discount = %v, Total = %v, want 30 and 0", q.Discount, q.Total)
+		}
+		if got := q.Adjustments[len(q.Adjustments)-1].Amount; got != 15 {
+			t.Errorf("second discount = %v, want it capped at 15", got)
+		}
+	})
+
+	t.Run("line", func(t *testing.T) {
+		lines := []Line{
+			{ProductID: "a", Quantity: 1, UnitPrice: 10},
+			{ProductID: "b", Quantity: 1, UnitPrice: 40},
+		}
+		q := price(t, NewEngine(Promotions{}), lines,
+			Promotion{Code: "AFREE", Kind: PromotionPercentage, Value: 100, ProductIDs: []string{"a"}},
+			Promotion{Code: "AHALF", Kind: PromotionPercentage, Value: 50, ProductIDs: []string{"a"}},
+		)
+		if got := lineDiscount(q, "a"); got != 10 {
+			t.Errorf("line a discount = %v, want 10", got)
+		}
+		if got := lineDiscount(q, "b"); got != 0 {
+			t.Errorf("line b discount = %v, want 0", got)
+		}
+		if q.Total != 40 {
+			t.Errorf("Total = %v, want 40", q.Total)
+		}
+	})
+
+	t.Run("shipping", func(t *testing.T) {
+		e := NewEngine(Promotions{}, FlatShipping{Rates: map[string]float64{"USD": 5}}, FreeShipping{})
+		lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 20}}
+		q := price(t, e, lines,
+			Promotion{Code: "SHIP1", Kind: PromotionFreeShipping},
+			Promotion{Code: "SHIP2", Kind: PromotionFreeShipping},
+		)
+		if q.Shipping != 0 || q.Total != 20 {
+			t.Errorf("Shipping = %v, Total = %v, want 0 and 20", q.Shipping, q.Total)
+		}
+		if q.Promotions[1].Applied {
+			t.Error("second free-shipping promotion applied twice")
+		}
+	})
+}
+
+func TestBuyXGetYDiscountsCheapestUnits(t *testing.T) {
+	lines := []Line{
+		{ProductID: "shirt", Quantity: 2, UnitPrice: 30},
+		{ProductID: "socks", Quantity: 1, UnitPrice: 5},
+		{ProductID: "hat", Quantity: 1, UnitPrice: 15},
+	}
+
+	tests := []struct {
+		name      string
+		promotion Promotion
+		discounts map[string]float64
+	}{
+		// Four units make two pairs; the two cheapest units are free.
+		{
+			"buy one get one free",
+			Promotion{Code: "BOGO", Kind: PromotionBuyXGetY, BuyQuantity: 1, GetQuantity: 1, Value: 100},
+			map[string]float64{"shirt": 0, "socks": 5, "hat": 15},
+		},
+		{
+			"buy one get one half off",
+			Promotion{Code: "BOGOHALF", Kind: PromotionBuyXGetY, BuyQuantity: 1, GetQuantity: 1, Value: 50},
+			map[string]float64{"shirt": 0, "socks": 2.5, "hat": 7.5},
+		},
+		// Only one complete set of three; the single cheapest unit is free.
+		{
+			"buy two get one free",
+			Promotion{Code: "B2G1", Kind: PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Value: 100},
+			map[string]float64{"shirt": 0, "socks": 5, "hat": 0},
+		},
+		// Limited to shirts, so both free units come from the same line.
+		{
+			"restricted to one product",
+			Promotion{Code: "SHIRTS", Kind: PromotionBuyXGetY, BuyQuantity: 1, GetQuantity: 1, Value: 100, ProductIDs: []string{"shirt"}},
+			map[string]float64{"shirt": 30, "socks": 0, "hat": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := price(t, NewEngine(Promotions{}), lines, tt.promotion)
+			want := 0.0
+			for id, discount := range tt.discounts {
+				if got := lineDiscount(q, id); got != discount {
+					t.Errorf("%s discount = %v, want %v", id, got, discount)
+				}
+				want += discount
+			}
+			if q.Discount != want {
+				t.Errorf("Discount = %v, want %v", q.Discount, want)
+			}
+		})
+	}
+}
+
+func TestBuyXGetYReportsMissingItems(t *testing.T) {
+	lines := []Line{{ProductID: "a", Quantity: 1, UnitPrice: 10}}
+	q := price(t, NewEngine(Promotions{}), lines,
+		Promotion{Code: "BOGO", Kind: PromotionBuyXGetY, BuyQuantity: 1, GetQuantity: 1, Value: 100},
+	)
+	if q.Discount != 0 {
+		t.Errorf("Discount = %v, want 0", q.Discount)
+	}
+	if r := q.Promotions[0]; r.Applied || r.Reason != "add 2 eligible items to get 1 discounted" {
+		t.Errorf("result = %+v, want not applied with a reason", r)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/ecommerce/order/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// couponError is a coupon that can no longer be redeemed.
+type couponError struct {
+	Code   string
+	Reason string
+}
+
+func (e *couponError) Error() string {
+	return fmt.Sprintf("coupon %s %s", e.Code, e.Reason)
+}
+
+// fetchCart reads the caller's priced cart from the cart service, so orders
+// are charged exactly what the cart showed. The caller's auth token is passed
+// on, so the cart service identifies them the same way this service did.
+func (h *OrderHandler) fetchCart(r *http.Request) (*models.Cart, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, h.CartURL+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	resp, err := h.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cart service: %s", resp.Status)
+	}
+	var cart models.Cart
+	if err := json.NewDecoder(resp.Body).Decode(&cart); err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// cartDiscounts splits what the cart's coupons took off into the merchandise
+// discount and the shipping discount. Free shipping lowers the shipping
+// charge, not the merchandise, so it is kept apart.
+func cartDiscounts(totals *models.CartTotals) (discount, shipping float64) {
+	for _, a := range totals.Adjustments {
+		if a.Type == models.AdjustmentShippingDiscount {
+			shipping += a.Amount
+		}
+	}
+	return totals.Discount, math.Round(shipping*100) / 100
+}
+
+// expandLines asks the product service which order lines are bundles and
+// what components ship for them, returning one expanded line per item.
+func (h *OrderHandler) expandLines(ctx context.Context, items []models.OrderLine) ([]models.ExpandedLine, error) {
+	expand := models.ExpandRequest{Items: make([]models.OrderEventItem, len(items))}
+	for i, item := range items {
+		expand.Items[i] = models.OrderEventItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+	body, err := json.Marshal(expand)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.ProductURL+"/bundles/expand", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("product service: %s", resp.Status)
+	}
+	var lines []models.ExpandedLine
+	if err := json.NewDecoder(resp.Body).Decode(&lines); err != nil {
+		return nil, err
+	}
+	if len(lines) != len(items) {
+		return nil, fmt.Errorf("product service expanded %d lines into %d", len(items), len(lines))
+	}
+	return lines, nil
+}
+
+// redeemCoupons records the coupons an order used, in the order's
+// transaction. Each promotion row is locked while its usage limits are
+// rechecked, so concurrent checkouts cannot redeem a coupon past them.
+func redeemCoupons(tx *sqlx.Tx, orderID, userID string, coupons []models.CartCoupon) error {
+	for _, c := range coupons {
+		var p struct {
+			MaxRedemptions   *int `db:"max_redemptions"`
+			PerCustomerLimit *int `db:"per_customer_limit"`
+			FirstOrderOnly   bool `db:"first_order_only"`
+			Live             bool `db:"live"`
+		}
+		err := tx.QueryRowx(
+			`SELECT max_redemptions, per_customer_limit, first_order_only,
+				starts_at <= NOW() AND (ends_at IS NULL OR ends_at > NOW()) AS live
+			 FROM cart.promotions WHERE id = $1 FOR UPDATE`, c.PromotionID,
+		).StructScan(&p)
+		if err == sql.ErrNoRows {
+			return &couponError{c.Code, "no longer exists"}
+		}
+		if err != nil {
+			return err
+		}
+		if !p.Live {
+			return &couponError{c.Code, "is no longer valid"}
+		}
+
+		var total, mine int
+		err = tx.QueryRow(
+			`SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id = $2) FROM cart.coupon_redemptions WHERE promotion_id = $1`,
+			c.PromotionID, userID,
+		).Scan(&total, &mine)
+		if err != nil {
+			return err
+		}
+		switch {
+		case p.MaxRedemptions != nil && total >= *p.MaxRedemptions:
+			return &couponError{c.Code, "has been fully redeemed"}
+		case p.PerCustomerLimit != nil && mine >= *p.PerCustomerLimit:
+			return &couponError{c.Code, "has already been used"}
+		}
+		if p.FirstOrderOnly {
+			var ordered bool
+			err := tx.QueryRow(
+				`SELECT EXISTS (SELECT 1 FROM orders.orders WHERE user_id = $1 AND id <> $2)`, userID, orderID,
+			).Scan(&ordered)
+			if err != nil {
+				return err
+			}
+			if ordered {
+				return &couponError{c.Code, "is only valid on a first order"}
+			}
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO cart.coupon_redemptions (promotion_id, user_id, order_id, amount) VALUES ($1, $2, $3, $4)`,
+			c.PromotionID, userID, orderID, c.Amount,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearCart removes the checked-out items and redeemed coupons from the
+// user's cart, in the order's transaction. Items added after the cart was
+// priced stay for the next order.
+func clearCart(tx *sqlx.Tx, userID string, items []models.OrderLine, coupons []models.CartCoupon) error {
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	promotionIDs := make([]string, len(coupons))
+	for i, c := range coupons {
+		promotionIDs[i] = c.PromotionID
+	}
+	_, err := tx.Exec(
+		`DELETE FROM cart.cart_items ci USING cart.carts c
+		 WHERE ci.cart_id = c.id AND c.user_id = $1 AND ci.product_id::text = ANY($2)`,
+		userID, pq.Array(productIDs))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`DELETE FROM cart.cart_coupons cc USING cart.carts c
+		 WHERE cc.cart_id = c.id AND c.user_id = $1 AND cc.promotion_id::text = ANY($2)`,
+		userID, pq.Array(promotionIDs))
+	return err
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/ecommerce/order/models"
@@ -14,36 +18,105 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const orderColumns = `id, user_id, total, discount, shipping_discount, currency, status, created_at, updated_at`
+
+const orderItemColumns = `id, order_id, product_id, quantity, price, bundle_item_id`
+
 type OrderHandler struct {
-	DB      *sqlx.DB
-	Channel *amqp.Channel
+	DB              *sqlx.DB
+	Channel         *amqp.Channel
+	DefaultCurrency string
+	CartURL         string
+	ProductURL      string
+	HTTPClient      *http.Client
 }
 
 func NewOrderHandler(db *sqlx.DB, ch *amqp.Channel) *OrderHandler {
-	return &OrderHandler{DB: db, Channel: ch}
+	currency := "USD"
+	if v := os.Getenv("DEFAULT_CURRENCY"); len(v) == 3 {
+		currency = strings.ToUpper(v)
+	}
+	cartURL := os.Getenv("CART_SERVICE_URL")
+	if cartURL == "" {
+		cartURL = "http://cart-service:8083"
+	}
+	productURL := os.Getenv("PRODUCT_SERVICE_URL")
+	if productURL == "" {
+		productURL = "http://product-service:8082"
+	}
+	return &OrderHandler{
+		DB:              db,
+		Channel:         ch,
+		DefaultCurrency: currency,
+		CartURL:         cartURL,
+		ProductURL:      productURL,
+		HTTPClient:      &http.Client{Timeout: 5 * time.Second},
+	}
 }
 
 func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("X-User-ID")
 	if userID == "" {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing user ID"})
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
 		return
 	}
 
+	// Orders are always placed from the cart, so the client has no say in
+	// what is charged
 	var req models.CreateOrderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
 		return
 	}
-	if len(req.Items) == 0 {
+	if len(req.Items) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "orders are placed from the cart; add the items to the cart instead"})
+		return
+	}
+
+	// Check out the cart, at the cart service's prices and discounts
+	cart, err := h.fetchCart(r)
+	switch {
+	case err != nil:
+		log.Printf("Failed to fetch cart: %v", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "cart service unavailable"})
+		return
+	case cart.Totals == nil || len(cart.Totals.Lines) == 0:
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at least one item is required"})
 		return
+	case cart.Stale:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "cart prices could not be confirmed"})
+		return
+	case cart.Unavailable:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "cart has items that are unavailable or short of stock"})
+		return
+	}
+	items := make([]models.OrderLine, 0, len(cart.Totals.Lines))
+	for _, line := range cart.Totals.Lines {
+		items = append(items, models.OrderLine{
+			ProductID: line.ProductID,
+			Quantity:  line.Quantity,
+			Price:     line.UnitPrice,
+		})
+	}
+	var coupons []models.CartCoupon
+	for _, c := range cart.Coupons {
+		if c.Applied {
+			coupons = append(coupons, c)
+		}
+	}
+	discount, shippingDiscount := cartDiscounts(cart.Totals)
+	total := cart.Totals.Total
+	currency := strings.ToUpper(cart.Totals.Currency)
+	if currency == "" {
+		currency = h.DefaultCurrency
 	}
 
-	// Calculate total
-	var total float64
-	for _, item := range req.Items {
-		total += item.Price * float64(item.Quantity)
+	// Bundles ship as their components
+	lines, err := h.expandLines(r.Context(), items)
+	if err != nil {
+		log.Printf("Failed to expand bundles: %v", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "product service unavailable"})
+		return
 	}
 
 	tx, err := h.DB.Beginx()
@@ -56,27 +129,57 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	// Create order
 	var order models.Order
 	err = tx.QueryRowx(
-		`INSERT INTO orders.orders (user_id, total) VALUES ($1, $2) 
-		 RETURNING id, user_id, total, status, created_at, updated_at`,
-		userID, total,
+		`INSERT INTO orders.orders (user_id, total, discount, shipping_discount, currency) VALUES ($1, $2, $3, $4, $5)
+		 RETURNING `+orderColumns,
+		userID, total, discount, shippingDiscount, currency,
 	).StructScan(&order)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create order"})
 		return
 	}
 
-	// Create order items
-	for _, item := range req.Items {
-		_, err = tx.Exec(
-			`INSERT INTO orders.order_items (order_id, product_id, quantity, price) VALUES ($1, $2, $3, $4)`,
+	// Create order items. Component lines of a bundle carry no price of their
+	// own and point at the bundle's line.
+	for i, item := range items {
+		var lineID string
+		err = tx.QueryRow(
+			`INSERT INTO orders.order_items (order_id, product_id, quantity, price) VALUES ($1, $2, $3, $4) RETURNING id`,
 			order.ID, item.ProductID, item.Quantity, item.Price,
-		)
+		).Scan(&lineID)
+		for _, c := range lines[i].Components {
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec(
+				`INSERT INTO orders.order_items (order_id, product_id, quantity, price, bundle_item_id) VALUES ($1, $2, $3, 0, $4)`,
+				order.ID, c.ProductID, c.Quantity, lineID,
+			)
+		}
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create order item"})
 			return
 		}
 	}
 
+	// Redeem coupons with the order, so an order never exists without its
+	// redemptions or the other way round
+	err = redeemCoupons(tx, order.ID, userID, coupons)
+	var couponErr *couponError
+	if errors.As(err, &couponErr) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": couponErr.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to redeem coupons"})
+		return
+	}
+
+	// Empty the cart with the order, so it cannot be checked out twice
+	if err := clearCart(tx, userID, items, coupons); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to clear cart"})
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
 		return
@@ -84,20 +187,27 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	// Publish order event to RabbitMQ
 	event := models.OrderEvent{
-		OrderID: order.ID,
-		UserID:  userID,
-		Total:   total,
-		Status:  "pending",
+		OrderID:  order.ID,
+		UserID:   userID,
+		Total:    total,
+		Currency: order.Currency,
+		Status:   "pending",
+	}
+	for _, line := range lines {
+		if len(line.Components) == 0 {
+			event.Items = append(event.Items, models.OrderEventItem{ProductID: line.ProductID, Quantity: line.Quantity})
+		}
+		event.Items = append(event.Items, line.Components...)
 	}
 	h.publishOrderEvent(event)
 
 	// Fetch items for response
-	var items []models.OrderItem
-	h.DB.Select(&items, `SELECT id, order_id, product_id, quantity, price FROM orders.order_items WHERE order_id = $1`, order.ID)
-	if items == nil {
-		items = []models.OrderItem{}
+	var orderItems []models.OrderItem
+	h.DB.Select(&orderItems, `SELECT `+orderItemColumns+` FROM orders.order_items WHERE order_id = $1`, order.ID)
+	if orderItems == nil {
+		orderItems = []models.OrderItem{}
 	}
-	order.Items = items
+	order.Items = orderItems
 
 	writeJSON(w, http.StatusCreated, order)
 }
@@ -105,13 +215,13 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 func (h *OrderHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("X-User-ID")
 	if userID == "" {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing user ID"})
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
 		return
 	}
 
 	var orders []models.Order
 	err := h.DB.Select(&orders,
-		`SELECT id, user_id, total, status, created_at, updated_at FROM orders.orders WHERE user_id = $1 ORDER BY created_at DESC`,
+		`SELECT `+orderColumns+` FROM orders.orders WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
 	if err != nil {
@@ -124,11 +234,18 @@ func (h *OrderHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, orders)
 }
 
+// GetOrder returns one of the caller's orders. Other users' orders are
+// reported as not found.
 func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+		return
+	}
 	id := chi.URLParam(r, "id")
 	var order models.Order
 	err := h.DB.QueryRowx(
-		`SELECT id, user_id, total, status, created_at, updated_at FROM orders.orders WHERE id = $1`, id,
+		`SELECT `+orderColumns+` FROM orders.orders WHERE id = $1 AND user_id = $2`, id, userID,
 	).StructScan(&order)
 	if err == sql.ErrNoRows {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "order not found"})
@@ -140,7 +257,7 @@ func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var items []models.OrderItem
-	h.DB.Select(&items, `SELECT id, order_id, product_id, quantity, price FROM orders.order_items WHERE order_id = $1`, order.ID)
+	h.DB.Select(&items, `SELECT `+orderItemColumns+` FROM orders.order_items WHERE order_id = $1`, order.ID)
 	if items == nil {
 		items = []models.OrderItem{}
 	}
@@ -200,9 +317,10 @@ func (h *OrderHandler) StartPaymentStatusConsumer() {
 			log.Printf("Failed to unmarshal payment event: %v", err)
 			continue
 		}
+		// An order that could not be reserved stays out of stock.
 		_, err := h.DB.Exec(
-			`UPDATE orders.orders SET status = $1, updated_at = NOW() WHERE id = $2`,
-			event.Status, event.OrderID,
+			`UPDATE orders.orders SET status = $1, updated_at = NOW() WHERE id = $2 AND status <> $3`,
+			event.Status, event.OrderID, models.StatusOutOfStock,
 		)
 		if err != nil {
 			log.Printf("Failed to update order status: %v", err)
@@ -212,6 +330,50 @@ func (h *OrderHandler) StartPaymentStatusConsumer() {
 	}
 }
 
+// StartReservationFailedConsumer marks orders the product service could not
+// reserve stock for as out of stock.
+func (h *OrderHandler) StartReservationFailedConsumer() {
+	q, err := h.Channel.QueueDeclare("orders.reservation.failed", true, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare queue: %v", err)
+		return
+	}
+
+	err = h.Channel.QueueBind(q.Name, "reservation.failed", "orders", false, nil)
+	if err != nil {
+		log.Printf("Failed to bind queue: %v", err)
+		return
+	}
+
+	msgs, err := h.Channel.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to start consuming: %v", err)
+		return
+	}
+
+	log.Println("Started reservation failure consumer")
+	for msg := range msgs {
+		var event models.ReservationFailedEvent
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			log.Printf("Failed to unmarshal reservation event: %v", err)
+			msg.Ack(false)
+			continue
+		}
+		_, err := h.DB.Exec(
+			`UPDATE orders.orders SET status = $1, updated_at = NOW() WHERE id = $2`,
+			models.StatusOutOfStock, event.OrderID,
+		)
+		if err != nil {
+			log.Printf("Failed to mark order %s out of stock, retrying: %v", event.OrderID, err)
+			time.Sleep(5 * time.Second)
+			msg.Nack(false, true)
+			continue
+		}
+		log.Printf("Order %s is out of stock: %s", event.OrderID, event.Reason)
+		msg.Ack(false)
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
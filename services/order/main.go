@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/ecommerce/auth/identity"
 	"github.com/ecommerce/order/handlers"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -33,6 +34,11 @@ func main() {
 	}
 	defer db.Close()
 
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatal("JWT_SECRET must be set")
+	}
+
 	conn, err := amqp.Dial(rabbitURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
@@ -51,12 +57,15 @@ func main() {
 
 	orderHandler := handlers.NewOrderHandler(db, ch)
 
-	// Start background consumer for payment status updates
+	// Start background consumers for payment status updates and failed
+	// stock reservations
 	go orderHandler.StartPaymentStatusConsumer()
+	go orderHandler.StartReservationFailedConsumer()
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(identity.Middleware([]byte(jwtSecret)))
 
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if err := db.Ping(); err != nil {
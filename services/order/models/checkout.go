@@ -0,0 +1,45 @@
+package models
+
+// Cart is the caller's cart as the cart service prices it. Only the fields
+// checkout needs are decoded.
+type Cart struct {
+	Stale       bool         `json:"stale"`
+	Unavailable bool         `json:"unavailable"`
+	Totals      *CartTotals  `json:"totals"`
+	Coupons     []CartCoupon `json:"coupons"`
+}
+
+type CartTotals struct {
+	Currency    string           `json:"currency"`
+	Lines       []CartLine       `json:"lines"`
+	Subtotal    float64          `json:"subtotal"`
+	Discount    float64          `json:"discount"`
+	Shipping    float64          `json:"shipping"`
+	Tax         float64          `json:"tax"`
+	Total       float64          `json:"total"`
+	Adjustments []CartAdjustment `json:"adjustments"`
+}
+
+// AdjustmentShippingDiscount is the cart adjustment type for what a
+// free-shipping coupon took off shipping.
+const AdjustmentShippingDiscount = "shipping_discount"
+
+// CartAdjustment is an entry in the cart's price breakdown.
+type CartAdjustment struct {
+	Type   string  `json:"type"`
+	Amount float64 `json:"amount"`
+}
+
+type CartLine struct {
+	ProductID string  `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+}
+
+// CartCoupon is a coupon on the cart. Amount is the discount it gave.
+type CartCoupon struct {
+	PromotionID string  `json:"promotion_id"`
+	Code        string  `json:"code"`
+	Applied     bool    `json:"applied"`
+	Amount      float64 `json:"amount"`
+}
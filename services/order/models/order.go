@@ -1,38 +1,86 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
+// StatusOutOfStock marks an order whose stock could not be reserved. Later
+// payment updates do not change it.
+const StatusOutOfStock = "out_of_stock"
+
+// Order is a checked-out cart. Discount is what coupons took off the
+// merchandise and ShippingDiscount what they took off shipping; both are
+// already reflected in Total.
 type Order struct {
-	ID        string      `json:"id" db:"id"`
-	UserID    string      `json:"user_id" db:"user_id"`
-	Total     float64     `json:"total" db:"total"`
-	Status    string      `json:"status" db:"status"`
-	Items     []OrderItem `json:"items"`
-	CreatedAt time.Time   `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at" db:"updated_at"`
+	ID               string      `json:"id" db:"id"`
+	UserID           string      `json:"user_id" db:"user_id"`
+	Total            float64     `json:"total" db:"total"`
+	Discount         float64     `json:"discount" db:"discount"`
+	ShippingDiscount float64     `json:"shipping_discount" db:"shipping_discount"`
+	Currency         string      `json:"currency" db:"currency"`
+	Status           string      `json:"status" db:"status"`
+	Items            []OrderItem `json:"items"`
+	CreatedAt        time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at" db:"updated_at"`
 }
 
+// OrderItem is a line of an order. Each component of a bundle gets a line of
+// its own, which points back at the bundle's line through BundleItemID and
+// has no price of its own.
 type OrderItem struct {
-	ID        string  `json:"id" db:"id"`
-	OrderID   string  `json:"order_id" db:"order_id"`
-	ProductID string  `json:"product_id" db:"product_id"`
-	Quantity  int     `json:"quantity" db:"quantity"`
-	Price     float64 `json:"price" db:"price"`
+	ID           string  `json:"id" db:"id"`
+	OrderID      string  `json:"order_id" db:"order_id"`
+	ProductID    string  `json:"product_id" db:"product_id"`
+	Quantity     int     `json:"quantity" db:"quantity"`
+	Price        float64 `json:"price" db:"price"`
+	BundleItemID *string `json:"bundle_item_id,omitempty" db:"bundle_item_id"`
 }
 
+// CreateOrderRequest is the body of an order request. Orders are always
+// placed from the caller's cart at the cart service's prices; Items is only
+// read so that clients still posting their own lines are turned away.
 type CreateOrderRequest struct {
-	Items []OrderItemRequest `json:"items"`
+	Items []json.RawMessage `json:"items"`
 }
 
-type OrderItemRequest struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+// OrderLine is one priced line of the cart being checked out.
+type OrderLine struct {
+	ProductID string
+	Quantity  int
+	Price     float64
 }
 
 type OrderEvent struct {
-	OrderID string  `json:"order_id"`
-	UserID  string  `json:"user_id"`
-	Total   float64 `json:"total"`
-	Status  string  `json:"status"`
+	OrderID  string           `json:"order_id"`
+	UserID   string           `json:"user_id"`
+	Total    float64          `json:"total"`
+	Currency string           `json:"currency,omitempty"`
+	Status   string           `json:"status"`
+	Items    []OrderEventItem `json:"items,omitempty"`
+}
+
+type OrderEventItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// ReservationFailedEvent is published by the product service when an order's
+// stock cannot be reserved.
+type ReservationFailedEvent struct {
+	OrderID   string `json:"order_id"`
+	Reason    string `json:"reason"`
+	ProductID string `json:"product_id,omitempty"`
+}
+
+type ExpandRequest struct {
+	Items []OrderEventItem `json:"items"`
+}
+
+// ExpandedLine is an order line as the product service expands it: the
+// components shipped for it, if it is a bundle.
+type ExpandedLine struct {
+	ProductID  string           `json:"product_id"`
+	Quantity   int              `json:"quantity"`
+	Components []OrderEventItem `json:"components"`
 }
@@ -94,19 +94,84 @@ func (h *PaymentHandler) processPayment(event models.OrderEvent) {
 		status = "failed"
 	}
 
-	// Store payment record
-	_, err := h.DB.Exec(
-		`INSERT INTO payment.payments (order_id, amount, status) VALUES ($1, $2, $3)`,
+	// Store payment record. An order that could not be reserved already has
+	// a cancelled payment and is not charged.
+	result, err := h.DB.Exec(
+		`INSERT INTO payment.payments (order_id, amount, status) VALUES ($1, $2, $3) ON CONFLICT (order_id) DO NOTHING`,
 		event.OrderID, event.Total, status,
 	)
 	if err != nil {
 		log.Printf("Failed to store payment: %v", err)
 		// Still publish status update
+	} else if n, _ := result.RowsAffected(); n == 0 {
+		log.Printf("Order %s already has a payment, not charging it", event.OrderID)
+		return
+	}
+
+	h.publishStatus(event.OrderID, status)
+}
+
+// StartReservationFailedConsumer stops payment for orders whose stock could
+// not be reserved. Orders not yet charged get a cancelled payment, which
+// processPayment then leaves alone; orders already charged are refunded.
+func (h *PaymentHandler) StartReservationFailedConsumer() {
+	q, err := h.Channel.QueueDeclare("payment.reservation.failed", true, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare queue: %v", err)
+		return
+	}
+
+	err = h.Channel.QueueBind(q.Name, "reservation.failed", "orders", false, nil)
+	if err != nil {
+		log.Printf("Failed to bind queue: %v", err)
+		return
+	}
+
+	msgs, err := h.Channel.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to start consuming: %v", err)
+		return
+	}
+
+	log.Println("Started reservation failure consumer for payment cancellation")
+	for msg := range msgs {
+		var event models.ReservationFailedEvent
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			log.Printf("Failed to unmarshal reservation failed event: %v", err)
+			msg.Ack(false)
+			continue
+		}
+
+		var status string
+		err := h.DB.QueryRow(
+			`INSERT INTO payment.payments (order_id, amount, status) VALUES ($1, 0, 'cancelled')
+			 ON CONFLICT (order_id) DO UPDATE SET status = 'refunded', updated_at = NOW()
+			 WHERE payment.payments.status = 'completed'
+			 RETURNING status`,
+			event.OrderID,
+		).Scan(&status)
+		switch {
+		case err == sql.ErrNoRows:
+			// The payment already failed; there is nothing to take back
+		case err != nil:
+			log.Printf("Failed to cancel payment for order %s, retrying: %v", event.OrderID, err)
+			time.Sleep(5 * time.Second)
+			msg.Nack(false, true)
+			continue
+		case status == "refunded":
+			log.Printf("Refunded payment for order %s: %s", event.OrderID, event.Reason)
+			h.publishStatus(event.OrderID, status)
+		default:
+			log.Printf("Cancelled payment for order %s: %s", event.OrderID, event.Reason)
+		}
+		msg.Ack(false)
 	}
+}
 
-	// Publish payment status back to order service
+// publishStatus reports a payment's status back to the order service.
+func (h *PaymentHandler) publishStatus(orderID, status string) {
 	statusEvent := models.PaymentStatusEvent{
-		OrderID: event.OrderID,
+		OrderID: orderID,
 		Status:  status,
 	}
 	body, err := json.Marshal(statusEvent)
@@ -130,7 +195,7 @@ func (h *PaymentHandler) processPayment(event models.OrderEvent) {
 	if err != nil {
 		log.Printf("Failed to publish payment status: %v", err)
 	} else {
-		log.Printf("Payment for order %s: %s", event.OrderID, status)
+		log.Printf("Payment for order %s: %s", orderID, status)
 	}
 }
 
@@ -51,8 +51,9 @@ func main() {
 
 	paymentHandler := handlers.NewPaymentHandler(db, ch)
 
-	// Start background consumer for order events
+	// Start background consumers for order events
 	go paymentHandler.StartOrderConsumer()
+	go paymentHandler.StartReservationFailedConsumer()
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -22,3 +22,10 @@ type PaymentStatusEvent struct {
 	OrderID string `json:"order_id"`
 	Status  string `json:"status"`
 }
+
+// ReservationFailedEvent reports an order whose stock could not be reserved.
+// Such orders are not charged, or are refunded if they already were.
+type ReservationFailedEvent struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
@@ -0,0 +1,100 @@
+// Package cache provides the read-through cache used for product reads, with
+// an in-process LRU backend and a Redis-compatible backend.
+package cache
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache is a byte-oriented key/value store with per-entry TTLs. Backends treat
+// their own failures as misses, so callers never have to handle cache errors.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+	DeletePrefix(ctx context.Context, prefix string)
+}
+
+var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "product_cache_requests_total",
+	Help: "Product cache lookups by cache name and result (hit or miss).",
+}, []string{"cache", "result"})
+
+// ReadThrough loads values through a Cache. Concurrent misses for the same
+// key share a single load, so an expired hot key does not stampede the
+// database.
+type ReadThrough struct {
+	Backend Cache
+	TTL     time.Duration
+	group   singleflight.Group
+}
+
+func NewReadThrough(backend Cache, ttl time.Duration) *ReadThrough {
+	return &ReadThrough{Backend: backend, TTL: ttl}
+}
+
+// Fetch returns the cached value for key, calling load on a miss and caching
+// its result. Errors from load are returned as-is and never cached. name
+// labels the hit/miss metrics.
+func (c *ReadThrough) Fetch(ctx context.Context, name, key string, load func() ([]byte, error)) ([]byte, bool, error) {
+	if value, ok := c.Backend.Get(ctx, key); ok {
+		requests.WithLabelValues(name, "hit").Inc()
+		return value, true, nil
+	}
+	requests.WithLabelValues(name, "miss").Inc()
+
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.Backend.Set(context.Background(), key, value, c.TTL)
+		return value, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value.([]byte), false, nil
+}
+
+// Invalidate drops every key starting with one of the prefixes.
+func (c *ReadThrough) Invalidate(ctx context.Context, prefixes ...string) {
+	for _, prefix := range prefixes {
+		c.Backend.DeletePrefix(ctx, prefix)
+	}
+}
+
+// FromEnv builds the cache configured by CACHE_BACKEND (lru, redis or none),
+// CACHE_TTL, CACHE_SIZE and REDIS_ADDR. It returns nil when caching is off.
+func FromEnv() *ReadThrough {
+	ttl := 30 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil && v > 0 {
+		ttl = v
+	}
+
+	switch os.Getenv("CACHE_BACKEND") {
+	case "none":
+		return nil
+	case "redis":
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "redis:6379"
+		}
+		log.Printf("Using Redis product cache at %s (ttl %s)", addr, ttl)
+		return NewReadThrough(NewRedis(addr), ttl)
+	default:
+		size := 1000
+		if v, err := strconv.Atoi(os.Getenv("CACHE_SIZE")); err == nil && v > 0 {
+			size = v
+		}
+		log.Printf("Using in-process LRU product cache (%d entries, ttl %s)", size, ttl)
+		return NewReadThrough(NewLRU(size), ttl)
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix namespaces product cache keys in a shared Redis.
+const keyPrefix = "product-cache:"
+
+// Redis is a cache backed by any server speaking the Redis protocol. Entries
+// are shared between replicas.
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(addr string) *Redis {
+	return &Redis{client: redis.NewClient(&redis.Options{
+		Addr:         addr,
+		DialTimeout:  time.Second,
+		ReadTimeout:  200 * time.Millisecond,
+		WriteTimeout: 200 * time.Millisecond,
+	})}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, bool) {
+	value, err := c.client.Get(ctx, keyPrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Redis cache get failed: %v", err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if err := c.client.Set(ctx, keyPrefix+key, value, ttl).Err(); err != nil {
+		log.Printf("Redis cache set failed: %v", err)
+	}
+}
+
+func (c *Redis) DeletePrefix(ctx context.Context, prefix string) {
+	iter := c.client.Scan(ctx, 0, keyPrefix+prefix+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Redis cache scan failed: %v", err)
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Redis cache delete failed: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package client calls the product service from other services. It has no
+// dependencies outside the standard library so that importing it does not
+// pull in the product service's own.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrNotFound is returned by Get for products that do not exist or are not
+// visible.
+var ErrNotFound = errors.New("product not found")
+
+// Error is a non-2xx response from the product service.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("product service: %d %s", e.StatusCode, e.Message)
+}
+
+// Product is a product as the product service returns it, priced in Currency
+// and translated into Locale.
+type Product struct {
+	ID             string     `json:"id"`
+	SKU            *string    `json:"sku"`
+	Name           string     `json:"name"`
+	Slug           *string    `json:"slug"`
+	Description    string     `json:"description"`
+	Category       *string    `json:"category"`
+	Images         []string   `json:"images"`
+	Price          float64    `json:"price"`
+	RegularPrice   float64    `json:"regular_price"`
+	CompareAtPrice *float64   `json:"compare_at_price,omitempty"`
+	Currency       string     `json:"currency"`
+	Locale         string     `json:"locale"`
+	Stock          int        `json:"stock"`
+	Available      int        `json:"available"`
+	IsBundle       bool       `json:"is_bundle"`
+	Status         string     `json:"status"`
+	Version        int        `json:"version"`
+	ArchivedAt     *time.Time `json:"archived_at,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// Options select how products are returned. Zero values use the product
+// service's defaults: base currency, default locale, no archived products.
+type Options struct {
+	Currency        string
+	Locale          string
+	IncludeArchived bool
+}
+
+// BatchResult holds the products found, keyed by ID, and the requested IDs
+// that were not.
+type BatchResult struct {
+	Products map[string]Product `json:"products"`
+	Missing  []string           `json:"missing"`
+}
+
+// Client calls the product service. Each attempt is bounded by
+// HTTPClient's timeout; failed attempts are retried up to Retries times with
+// exponential backoff starting at Backoff. Only connection errors, 429 and
+// 5xx responses are retried, since every call is a read.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Retries    int
+	Backoff    time.Duration
+}
+
+// New returns a client for the product service at baseURL, such as
+// http://product-service:8082, with a 2s timeout and two retries.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: 2 * time.Second},
+		Retries:    2,
+		Backoff:    100 * time.Millisecond,
+	}
+}
+
+// Batch looks up many products in one call.
+func (c *Client) Batch(ctx context.Context, ids []string, opts Options) (*BatchResult, error) {
+	body, err := json.Marshal(map[string]interface{}{"ids": ids, "include_archived": opts.IncludeArchived})
+	if err != nil {
+		return nil, err
+	}
+	var result BatchResult
+	if err := c.do(ctx, http.MethodPost, "/batch", opts, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Get looks up one product, returning ErrNotFound if there is none.
+func (c *Client) Get(ctx context.Context, id string, opts Options) (*Product, error) {
+	var product Product
+	err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(id), opts, nil, &product)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, opts Options, body []byte, out interface{}) error {
+	query := url.Values{}
+	if opts.Currency != "" {
+		query.Set("currency", opts.Currency)
+	}
+	if opts.Locale != "" {
+		query.Set("locale", opts.Locale)
+	}
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, target, body, out)
+		if err == nil || attempt >= c.Retries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, target string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var payload struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&payload)
+		return &Error{StatusCode: resp.StatusCode, Message: payload.Error}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryable reports whether a failed attempt may succeed if repeated:
+// connection errors and attempt timeouts, throttling and server errors.
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,3 @@
+module github.com/ecommerce/product/client
+
+go 1.22
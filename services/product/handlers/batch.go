@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/ecommerce/product/models"
+	"github.com/lib/pq"
+)
+
+// maxBatchIDs caps how many products one batch lookup may ask for.
+const maxBatchIDs = 200
+
+var productID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Batch returns many products in one call, priced and translated like Get.
+// It does not go through the cache: callers such as cart and order want
+// current prices and stock.
+func (h *ProductHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req models.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at least one id is required"})
+		return
+	}
+	if len(req.IDs) > maxBatchIDs {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at most 200 ids are allowed"})
+		return
+	}
+	currency, ok := h.Currencies.requestCurrency(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be an ISO 4217 code"})
+		return
+	}
+	locales, ok := h.Translations.requestLocales(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag such as fr or fr-CA"})
+		return
+	}
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range req.IDs {
+		if !seen[id] && productID.MatchString(id) {
+			ids = append(ids, id)
+		}
+		seen[id] = true
+	}
+	var products []models.Product
+	if len(ids) > 0 {
+		visible := publishedClause
+		if previewing(r) {
+			visible = "TRUE"
+		}
+		err := h.DB.Select(&products,
+			`SELECT `+productColumns+` FROM product.products
+			 WHERE id = ANY($1) AND ($2 OR archived_at IS NULL) AND `+visible,
+			pq.Array(ids), req.IncludeArchived)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch products"})
+			return
+		}
+	}
+	products, err := h.Currencies.Localize(products, currency)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to convert prices"})
+		return
+	}
+	if err := h.Translations.Translate(products, locales); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to translate products"})
+		return
+	}
+
+	resp := models.BatchResponse{Products: make(map[string]models.Product, len(products)), Missing: []string{}}
+	for _, p := range products {
+		resp.Products[p.ID] = p
+	}
+	reported := map[string]bool{}
+	for _, id := range req.IDs {
+		if _, found := resp.Products[id]; !found && !reported[id] {
+			reported[id] = true
+			resp.Missing = append(resp.Missing, id)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A bundle is a product with rows in product.bundle_components. It holds no
+// stock of its own: availability comes from its components. The order service
+// expands bundle lines into component lines through Expand when an order is
+// placed, and reservations expand any bundle lines they are given, so the
+// components are what gets decremented and shipped.
+const (
+	isBundleExpr = `EXISTS (SELECT 1 FROM product.bundle_components bc WHERE bc.bundle_id = products.id)`
+
+	bundleStockExpr = `(SELECT GREATEST(COALESCE(MIN(
+			CASE WHEN c.archived_at IS NULL THEN c.stock / bc.quantity ELSE 0 END), 0), 0)
+		FROM product.bundle_components bc JOIN product.products c ON c.id = bc.component_id
+		WHERE bc.bundle_id = products.id)`
+
+	bundleAvailableExpr = `(SELECT GREATEST(COALESCE(MIN(
+			CASE WHEN c.archived_at IS NULL THEN (c.stock - COALESCE((SELECT SUM(r.quantity) FROM product.reservations r
+				WHERE r.product_id = c.id AND r.status = 'active' AND r.expires_at > NOW()), 0)) / bc.quantity
+			ELSE 0 END), 0), 0)
+		FROM product.bundle_components bc JOIN product.products c ON c.id = bc.component_id
+		WHERE bc.bundle_id = products.id)`
+)
+
+type BundleHandler struct {
+	DB      *sqlx.DB
+	Channel *amqp.Channel
+}
+
+func NewBundleHandler(db *sqlx.DB, ch *amqp.Channel) *BundleHandler {
+	return &BundleHandler{DB: db, Channel: ch}
+}
+
+// Get returns the bundle's components and how its price compares to buying
+// them separately.
+func (h *BundleHandler) Get(w http.ResponseWriter, r *http.Request) {
+	bundle, err := loadBundle(h.DB, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch bundle"})
+		return
+	}
+	if bundle == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "bundle not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, bundle)
+}
+
+// Set replaces the product's components, turning it into a bundle. The
+// product must hold no stock of its own, and components cannot be bundles.
+func (h *BundleHandler) Set(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req models.SetBundleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Components) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at least one component is required"})
+		return
+	}
+	quantities := map[string]int{}
+	for _, c := range req.Components {
+		if c.ProductID == "" || c.Quantity <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "each component needs a product_id and positive quantity"})
+			return
+		}
+		if c.ProductID == id {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "a bundle cannot contain itself"})
+			return
+		}
+		quantities[c.ProductID] += c.Quantity
+	}
+	componentIDs := make([]string, 0, len(quantities))
+	for componentID := range quantities {
+		componentIDs = append(componentIDs, componentID)
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, id)
+	if !ok {
+		return
+	}
+	if before.ArchivedAt != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product is archived"})
+		return
+	}
+	var ownStock int
+	if err := tx.Get(&ownStock, `SELECT stock FROM product.products WHERE id = $1`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	if ownStock != 0 {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "move the product's own stock to zero before making it a bundle"})
+		return
+	}
+
+	var valid int
+	err = tx.Get(&valid,
+		`SELECT COUNT(*) FROM product.products p
+		 WHERE p.id = ANY($1) AND p.archived_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM product.bundle_components bc WHERE bc.bundle_id = p.id)`,
+		pq.Array(componentIDs))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to validate components"})
+		return
+	}
+	if valid != len(componentIDs) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "components must be existing, active products that are not bundles"})
+		return
+	}
+	var usedAsComponent bool
+	if err := tx.Get(&usedAsComponent, `SELECT EXISTS (SELECT 1 FROM product.bundle_components WHERE component_id = $1)`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to validate components"})
+		return
+	}
+	if usedAsComponent {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product is a component of another bundle"})
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM product.bundle_components WHERE bundle_id = $1`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update bundle"})
+		return
+	}
+	for componentID, quantity := range quantities {
+		_, err := tx.Exec(
+			`INSERT INTO product.bundle_components (bundle_id, component_id, quantity) VALUES ($1, $2, $3)`,
+			id, componentID, quantity)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update bundle"})
+			return
+		}
+	}
+	h.commitBundleChange(w, r, tx, before)
+}
+
+// Delete removes the product's components, turning it back into a plain
+// product with no stock.
+func (h *BundleHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, id)
+	if !ok {
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM product.bundle_components WHERE bundle_id = $1`, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update bundle"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "bundle not found"})
+		return
+	}
+	h.commitBundleChange(w, r, tx, before)
+}
+
+func (h *BundleHandler) commitBundleChange(w http.ResponseWriter, r *http.Request, tx *sqlx.Tx, before *models.Product) {
+	if _, err := tx.Exec(`UPDATE product.products SET version = version + 1, updated_at = NOW() WHERE id = $1`, before.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update bundle"})
+		return
+	}
+	if err := recordRevision(tx, before.ID, models.RevisionBundle, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	after, err := loadProduct(tx, before.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	bundle, err := loadBundle(tx, before.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch bundle"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	publishCatalogEvent(h.Channel, models.EventProductUpdated, before, after, actor(r))
+
+	w.Header().Set("ETag", etag(after.ID, after.Version))
+	if bundle == nil {
+		writeJSON(w, http.StatusOK, after)
+		return
+	}
+	writeJSON(w, http.StatusOK, bundle)
+}
+
+// Expand returns each order line with the component lines that are picked
+// and shipped for it, in the order given. Lines for plain products have no
+// components.
+func (h *BundleHandler) Expand(w http.ResponseWriter, r *http.Request) {
+	var req models.ExpandItemsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at least one item is required"})
+		return
+	}
+	components, err := bundleComponents(h.DB, req.Items)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to expand items"})
+		return
+	}
+	lines := make([]models.ExpandedLine, len(req.Items))
+	for i, item := range req.Items {
+		lines[i] = models.ExpandedLine{ProductID: item.ProductID, Quantity: item.Quantity, Components: []models.ReservationItem{}}
+		for _, c := range components[item.ProductID] {
+			lines[i].Components = append(lines[i].Components,
+				models.ReservationItem{ProductID: c.ProductID, Quantity: c.Quantity * item.Quantity})
+		}
+	}
+	writeJSON(w, http.StatusOK, lines)
+}
+
+// expandBundles replaces lines for active bundles with their components,
+// merging quantities per product. Archived bundles are left as they are so
+// that callers reject them like any other archived product.
+func expandBundles(q sqlx.Queryer, items []models.ReservationItem) ([]models.ReservationItem, error) {
+	components, err := bundleComponents(q, items)
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	quantities := map[string]int{}
+	add := func(productID string, quantity int) {
+		if _, ok := quantities[productID]; !ok {
+			order = append(order, productID)
+		}
+		quantities[productID] += quantity
+	}
+	for _, item := range items {
+		parts, ok := components[item.ProductID]
+		if !ok {
+			add(item.ProductID, item.Quantity)
+			continue
+		}
+		for _, c := range parts {
+			add(c.ProductID, c.Quantity*item.Quantity)
+		}
+	}
+	expanded := make([]models.ReservationItem, len(order))
+	for i, productID := range order {
+		expanded[i] = models.ReservationItem{ProductID: productID, Quantity: quantities[productID]}
+	}
+	return expanded, nil
+}
+
+// bundleComponents returns the components of one unit of each active bundle
+// among the items, keyed by bundle ID.
+func bundleComponents(q sqlx.Queryer, items []models.ReservationItem) (map[string][]models.ReservationItem, error) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+	rows, err := q.Queryx(
+		`SELECT bc.bundle_id, bc.component_id, bc.quantity FROM product.bundle_components bc
+		 JOIN product.products b ON b.id = bc.bundle_id
+		 WHERE bc.bundle_id = ANY($1) AND b.archived_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	components := map[string][]models.ReservationItem{}
+	for rows.Next() {
+		var bundleID string
+		var c models.ReservationItem
+		if err := rows.Scan(&bundleID, &c.ProductID, &c.Quantity); err != nil {
+			return nil, err
+		}
+		components[bundleID] = append(components[bundleID], c)
+	}
+	return components, rows.Err()
+}
+
+// loadBundle reads a bundle with its components, returning nil if the
+// product has none.
+func loadBundle(q sqlx.Queryer, id string) (*models.Bundle, error) {
+	var components []models.BundleComponent
+	err := sqlx.Select(q, &components,
+		`SELECT bc.component_id, products.name, bc.quantity, `+effectivePriceExpr+` AS price, `+availableExpr+` AS available
+		 FROM product.bundle_components bc JOIN product.products ON products.id = bc.component_id
+		 WHERE bc.bundle_id = $1 ORDER BY products.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, nil
+	}
+	product, err := loadProduct(q, id)
+	if err != nil || product == nil {
+		return nil, err
+	}
+
+	bundle := &models.Bundle{BundleID: id, Price: product.Price, Available: product.Available, Components: components}
+	for _, c := range components {
+		bundle.ComponentsPrice += c.Price * float64(c.Quantity)
+	}
+	bundle.ComponentsPrice = roundMoney(bundle.ComponentsPrice)
+	bundle.Savings = roundMoney(bundle.ComponentsPrice - bundle.Price)
+	return bundle, nil
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Read errors that map to client responses. They are returned from cache
+// loaders so that they are never cached.
+var (
+	errProductNotFound = errors.New("product not found")
+	errNotPriced       = errors.New("product is not priced in the requested currency")
+)
+
+// cached returns the JSON encoding of load's result, going through the
+// product cache when one is configured and the request is not an admin
+// preview. hit reports whether it was served from the cache.
+func (h *ProductHandler) cached(r *http.Request, name, key string, load func() (interface{}, error)) (body []byte, hit bool, err error) {
+	loadJSON := func() ([]byte, error) {
+		v, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+	if h.Cache == nil || previewing(r) {
+		body, err = loadJSON()
+		return body, false, err
+	}
+	return h.Cache.Fetch(r.Context(), name, key, loadJSON)
+}
+
+// writeCached writes a JSON body produced by cached, with headers telling
+// clients and proxies how long they may reuse it. Previews must not be
+// reused at all.
+func (h *ProductHandler) writeCached(w http.ResponseWriter, r *http.Request, body []byte, hit bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Language")
+	if previewing(r) {
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else if h.Cache != nil {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.Cache.TTL.Seconds())))
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// invalidate drops cached reads of the product and every cached list.
+func (h *ProductHandler) invalidate(productID string) {
+	if h.Cache == nil {
+		return
+	}
+	h.Cache.Invalidate(context.Background(), "product:"+productID+":", "list:")
+}
+
+// StartCacheInvalidator drops cached reads whenever a catalog event arrives,
+// which covers writes made by other replicas and by the importer, reservation
+// commits and the price scheduler. Each replica consumes through its own
+// exclusive queue. Changes that publish no event, such as reservations,
+// review moderation and exchange rates, show up once entries expire.
+func (h *ProductHandler) StartCacheInvalidator() {
+	if h.Cache == nil {
+		return
+	}
+	q, err := h.Channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare queue: %v", err)
+		return
+	}
+
+	err = h.Channel.QueueBind(q.Name, "product.#", catalogExchange, false, nil)
+	if err != nil {
+		log.Printf("Failed to bind queue: %v", err)
+		return
+	}
+
+	msgs, err := h.Channel.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to start consuming: %v", err)
+		return
+	}
+
+	log.Println("Started catalog consumer for cache invalidation")
+	for msg := range msgs {
+		var event struct {
+			ProductID string `json:"product_id"`
+		}
+		if err := json.Unmarshal(msg.Body, &event); err != nil || event.ProductID == "" {
+			log.Printf("Failed to unmarshal %s event: %v", msg.RoutingKey, err)
+			continue
+		}
+		h.invalidate(event.ProductID)
+	}
+}
+
+// cacheKey identifies a read by its normalized query string, since the query
+// selects currency, archived products and so on, and by the locales resolved
+// from ?locale= or Accept-Language.
+func cacheKey(prefix string, r *http.Request, locales []string) string {
+	return prefix + r.URL.Query().Encode() + "|" + strings.Join(locales, ",")
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// CurrencyHandler owns per-currency price lists and exchange rates, and
+// localizes product prices for a requested currency. Stored product prices,
+// sales and schedules are all in the base currency.
+type CurrencyHandler struct {
+	DB         *sqlx.DB
+	Base       string
+	FXFallback bool
+}
+
+func NewCurrencyHandler(db *sqlx.DB) *CurrencyHandler {
+	base := strings.ToUpper(os.Getenv("BASE_CURRENCY"))
+	if !currencyCode.MatchString(base) {
+		base = "USD"
+	}
+	return &CurrencyHandler{DB: db, Base: base, FXFallback: os.Getenv("FX_FALLBACK") != "false"}
+}
+
+// LoadRatesFile seeds exchange rates from a JSON file mapping currency codes
+// to units per one base currency unit, e.g. {"EUR": 0.92, "GBP": 0.79}.
+func (h *CurrencyHandler) LoadRatesFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rates map[string]float64
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return fmt.Errorf("invalid rates file: %w", err)
+	}
+	if err := h.saveRates(rates, "file"); err != nil {
+		return err
+	}
+	log.Printf("Loaded %d exchange rates from %s", len(rates), path)
+	return nil
+}
+
+func (h *CurrencyHandler) ListRates(w http.ResponseWriter, r *http.Request) {
+	var rates []models.ExchangeRate
+	if err := h.DB.Select(&rates, `SELECT currency, rate, source, updated_at FROM product.exchange_rates ORDER BY currency`); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch exchange rates"})
+		return
+	}
+	if rates == nil {
+		rates = []models.ExchangeRate{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"base": h.Base, "rates": rates})
+}
+
+// SetRates upserts the given rates, leaving other currencies untouched.
+func (h *CurrencyHandler) SetRates(w http.ResponseWriter, r *http.Request) {
+	var rates map[string]float64
+	if err := json.NewDecoder(r.Body).Decode(&rates); err != nil || len(rates) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must map currency codes to rates"})
+		return
+	}
+	if err := h.saveRates(rates, "admin"); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.ListRates(w, r)
+}
+
+func (h *CurrencyHandler) saveRates(rates map[string]float64, source string) error {
+	for code, rate := range rates {
+		if !currencyCode.MatchString(code) || code == h.Base {
+			return fmt.Errorf("invalid currency code %q", code)
+		}
+		if rate <= 0 {
+			return fmt.Errorf("rate for %s must be positive", code)
+		}
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for code, rate := range rates {
+		_, err := tx.Exec(
+			`INSERT INTO product.exchange_rates (currency, rate, source) VALUES ($1, $2, $3)
+			 ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, source = EXCLUDED.source, updated_at = NOW()`,
+			code, rate, source,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (h *CurrencyHandler) ListProductPrices(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	var prices []models.ProductPrice
+	err := h.DB.Select(&prices,
+		`SELECT product_id, currency, price, updated_at FROM product.product_prices WHERE product_id = $1 ORDER BY currency`,
+		productID,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch price list"})
+		return
+	}
+	if prices == nil {
+		prices = []models.ProductPrice{}
+	}
+	writeJSON(w, http.StatusOK, prices)
+}
+
+func (h *CurrencyHandler) SetProductPrice(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	currency := strings.ToUpper(chi.URLParam(r, "currency"))
+	if !currencyCode.MatchString(currency) || currency == h.Base {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be a non-base ISO 4217 code"})
+		return
+	}
+	var req models.SetProductPriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Price <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "positive price is required"})
+		return
+	}
+
+	var price models.ProductPrice
+	err := h.DB.QueryRowx(
+		`INSERT INTO product.product_prices (product_id, currency, price)
+		 SELECT id, $2, $3 FROM product.products WHERE id = $1
+		 ON CONFLICT (product_id, currency) DO UPDATE SET price = EXCLUDED.price, updated_at = NOW()
+		 RETURNING product_id, currency, price, updated_at`,
+		productID, currency, req.Price,
+	).StructScan(&price)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set price"})
+		return
+	}
+	writeJSON(w, http.StatusOK, price)
+}
+
+func (h *CurrencyHandler) DeleteProductPrice(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	currency := strings.ToUpper(chi.URLParam(r, "currency"))
+	result, err := h.DB.Exec(`DELETE FROM product.product_prices WHERE product_id = $1 AND currency = $2`, productID, currency)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete price"})
+		return
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "price not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "price deleted"})
+}
+
+// requestCurrency returns the normalized ?currency= parameter, defaulting to
+// the base currency. ok is false for malformed codes.
+func (h *CurrencyHandler) requestCurrency(r *http.Request) (currency string, ok bool) {
+	currency = strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		return h.Base, true
+	}
+	return currency, currencyCode.MatchString(currency)
+}
+
+// Localize converts products into currency. A price list entry sets the
+// regular price; an active sale keeps the same discount ratio it has in the
+// base currency. Without an entry the base prices are converted at the stored
+// exchange rate if FX fallback is enabled. Products that cannot be priced in
+// currency are left out of the result; products without a base price stay
+// free.
+func (h *CurrencyHandler) Localize(products []models.Product, currency string) ([]models.Product, error) {
+	if currency == h.Base {
+		for i := range products {
+			products[i].Currency = h.Base
+		}
+		return products, nil
+	}
+
+	ids := make([]string, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	var entries []models.ProductPrice
+	err := h.DB.Select(&entries,
+		`SELECT product_id, currency, price, updated_at FROM product.product_prices
+		 WHERE currency = $1 AND product_id = ANY($2)`,
+		currency, pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]float64, len(entries))
+	for _, e := range entries {
+		listed[e.ProductID] = e.Price
+	}
+
+	var rate float64
+	if h.FXFallback {
+		err := h.DB.Get(&rate, `SELECT rate FROM product.exchange_rates WHERE currency = $1`, currency)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	localized := make([]models.Product, 0, len(products))
+	for _, p := range products {
+		if p.RegularPrice <= 0 {
+			// A free product gives no ratio to convert by, and is free in
+			// every currency.
+			p.Price, p.RegularPrice, p.CompareAtPrice = 0, 0, nil
+			p.Currency = currency
+			localized = append(localized, p)
+			continue
+		}
+		factor := rate
+		if price, ok := listed[p.ID]; ok {
+			factor = price / p.RegularPrice
+		}
+		if factor == 0 {
+			continue
+		}
+		p.Price = roundMoney(p.Price * factor)
+		p.RegularPrice = roundMoney(p.RegularPrice * factor)
+		if p.CompareAtPrice != nil {
+			compareAt := roundMoney(*p.CompareAtPrice * factor)
+			p.CompareAtPrice = &compareAt
+		}
+		if price, ok := listed[p.ID]; ok {
+			p.RegularPrice = price
+		}
+		p.Currency = currency
+		localized = append(localized, p)
+	}
+	return localized, nil
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// etag identifies a product revision. It changes whenever the row's version
+// is bumped, so it can be used for both conditional reads and writes.
+func etag(id string, version int) string {
+	return `"` + id + `-v` + strconv.Itoa(version) + `"`
+}
+
+// representationTag identifies the exact bytes of a product read. The body
+// also depends on the effective price, availability, currency and locale,
+// none of which bump the version, so reads are tagged by their content. The
+// version stays in the tag so that it still works as an If-Match precondition.
+func representationTag(id string, version int, body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + id + `-v` + strconv.Itoa(version) + `-` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// versionMatches reports whether an If-Match header value matches the
+// product's current version, through either a version tag from a write or a
+// representation tag from a read.
+func versionMatches(header, id string, version int) bool {
+	tag := etag(id, version)
+	prefix := strings.TrimSuffix(tag, `"`) + "-"
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag || strings.HasPrefix(candidate, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// matches the given entity tag. Weak validators are compared by their opaque
+// value, and "*" matches any existing entity.
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
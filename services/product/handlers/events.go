@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// catalogExchange is the topic exchange product events are published to.
+const catalogExchange = "catalog"
+
+func publishEvent(ch *amqp.Channel, routingKey string, event interface{}) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", routingKey, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = ch.PublishWithContext(ctx,
+		catalogExchange,
+		routingKey,
+		false, false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to publish %s event: %v", routingKey, err)
+	}
+}
+
+func publishCatalogEvent(ch *amqp.Channel, eventType string, before, after *models.Product, actor string) {
+	publishEvent(ch, eventType, models.CatalogEvent{
+		Type:       eventType,
+		ProductID:  after.ID,
+		Before:     before,
+		After:      after,
+		Actor:      actor,
+		OccurredAt: time.Now(),
+	})
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ecommerce/product/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// feedBatchSize is how many products the feed reads, converts and writes at
+// a time, so memory stays flat however large the catalog is.
+const feedBatchSize = 500
+
+// FeedHandler serves the merchant feed that comparison-shopping sites crawl.
+type FeedHandler struct {
+	DB           *sqlx.DB
+	Currencies   *CurrencyHandler
+	Translations *TranslationHandler
+	SiteURL      string
+}
+
+func NewFeedHandler(db *sqlx.DB, currencies *CurrencyHandler, translations *TranslationHandler) *FeedHandler {
+	return &FeedHandler{DB: db, Currencies: currencies, Translations: translations, SiteURL: siteURL()}
+}
+
+// Merchant streams published products as a Google Merchant feed, in RSS 2.0
+// XML or CSV. Items are keyed by product ID, which never changes, and link to
+// the storefront page for the product's slug. Products are read in batches
+// from one repeatable-read snapshot and written as each batch is converted.
+func (h *FeedHandler) Merchant(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = models.FormatXML
+	}
+	if format != models.FormatXML && format != models.FormatCSV {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be xml or csv"})
+		return
+	}
+	currency, ok := h.Currencies.requestCurrency(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be an ISO 4217 code"})
+		return
+	}
+	locales, ok := h.Translations.requestLocales(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag such as fr or fr-CA"})
+		return
+	}
+
+	tx, err := h.DB.BeginTxx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Read the first batch before committing to a 200 so that a broken query
+	// still gets a proper error response.
+	batch, err := feedBatch(tx, firstFeedCursor)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate feed"})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="products.%s"`, format))
+	if format == models.FormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "public, max-age=900")
+	w.WriteHeader(http.StatusOK)
+
+	var feed feedWriter
+	if format == models.FormatCSV {
+		feed = newCSVFeed(w)
+	} else {
+		feed = newXMLFeed(w, h.SiteURL)
+	}
+	flusher, _ := w.(http.Flusher)
+	for len(batch) > 0 {
+		products, err := h.Currencies.Localize(batch, currency)
+		if err != nil {
+			log.Printf("Failed to convert feed prices: %v", err)
+			return
+		}
+		if err := h.Translations.Translate(products, locales); err != nil {
+			log.Printf("Failed to translate feed: %v", err)
+			return
+		}
+		for _, p := range products {
+			if err := feed.write(h.feedItem(p)); err != nil {
+				return
+			}
+		}
+		if err := feed.flush(); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(batch) < feedBatchSize {
+			break
+		}
+		if batch, err = feedBatch(tx, batch[len(batch)-1].ID); err != nil {
+			log.Printf("Failed to generate feed: %v", err)
+			return
+		}
+	}
+	if err := feed.close(); err != nil {
+		log.Printf("Failed to finish feed: %v", err)
+	}
+}
+
+// firstFeedCursor sorts before every product ID.
+const firstFeedCursor = "00000000-0000-0000-0000-000000000000"
+
+// feedBatch reads the next published products after the given ID.
+func feedBatch(tx *sqlx.Tx, after string) ([]models.Product, error) {
+	var products []models.Product
+	err := tx.Select(&products,
+		`SELECT `+productColumns+` FROM product.products
+		 WHERE id > $1 AND slug IS NOT NULL AND archived_at IS NULL AND `+publishedClause+`
+		 ORDER BY id LIMIT $2`,
+		after, feedBatchSize)
+	return products, err
+}
+
+func (h *FeedHandler) feedItem(p models.Product) models.FeedItem {
+	item := models.FeedItem{
+		ID:           p.ID,
+		Title:        p.Name,
+		Description:  p.Description,
+		Availability: models.FeedOutOfStock,
+		Price:        feedPrice(p.RegularPrice, p.Currency),
+		Condition:    "new",
+	}
+	if item.Description == "" {
+		item.Description = p.Name
+	}
+	if p.Slug != nil {
+		item.Link = h.SiteURL + "/products/" + *p.Slug
+	}
+	if len(p.Images) > 0 {
+		item.ImageLink = p.Images[0]
+		item.AdditionalImageLinks = p.Images[1:]
+	}
+	if p.Available > 0 {
+		item.Availability = models.FeedInStock
+	}
+	if p.Price < p.RegularPrice {
+		item.SalePrice = feedPrice(p.Price, p.Currency)
+	}
+	var attributes struct {
+		Brand string `json:"brand"`
+	}
+	json.Unmarshal(p.Attributes, &attributes)
+	item.Brand = attributes.Brand
+	if p.SKU != nil {
+		item.MPN = *p.SKU
+	}
+	if item.Brand == "" || item.MPN == "" {
+		item.IdentifierExists = "no"
+	}
+	if p.Category != nil {
+		item.ProductType = *p.Category
+	}
+	return item
+}
+
+func feedPrice(amount float64, currency string) string {
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+// feedWriter writes one feed format item by item.
+type feedWriter interface {
+	write(models.FeedItem) error
+	flush() error
+	close() error
+}
+
+type csvFeed struct {
+	w *csv.Writer
+}
+
+func newCSVFeed(w io.Writer) *csvFeed {
+	feed := &csvFeed{w: csv.NewWriter(w)}
+	feed.w.Write(models.FeedColumns)
+	return feed
+}
+
+func (f *csvFeed) write(item models.FeedItem) error {
+	return f.w.Write(item.CSVRecord())
+}
+
+func (f *csvFeed) flush() error {
+	f.w.Flush()
+	return f.w.Error()
+}
+
+func (f *csvFeed) close() error {
+	return f.flush()
+}
+
+type xmlFeed struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func newXMLFeed(w io.Writer, siteURL string) *xmlFeed {
+	feed := &xmlFeed{w: w, enc: xml.NewEncoder(w)}
+	feed.enc.Indent("    ", "  ")
+	io.WriteString(w, xml.Header+`<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0">`+"\n  <channel>\n")
+	for _, el := range [][2]string{{"title", "Products"}, {"link", siteURL}, {"description", "Product feed for " + siteURL}} {
+		feed.enc.EncodeElement(el[1], xml.StartElement{Name: xml.Name{Local: el[0]}})
+	}
+	return feed
+}
+
+func (f *xmlFeed) write(item models.FeedItem) error {
+	return f.enc.Encode(item)
+}
+
+func (f *xmlFeed) flush() error {
+	return f.enc.Flush()
+}
+
+func (f *xmlFeed) close() error {
+	if err := f.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(f.w, "\n  </channel>\n</rss>\n")
+	return err
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/ecommerce/product/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var errInsufficientWarehouseStock = errors.New("not enough stock across warehouses")
+
+// FulfilmentStrategy ranks the warehouses that could ship an order, best
+// first. allocate prefers the best-ranked warehouse that can ship the whole
+// order and otherwise splits lines across warehouses in rank order.
+type FulfilmentStrategy interface {
+	Name() string
+	Rank(candidates []models.FulfilmentCandidate)
+}
+
+// NearestStrategy ships from the warehouse closest to the destination.
+// Warehouses without a known distance come last, by stock.
+type NearestStrategy struct{}
+
+func (NearestStrategy) Name() string { return models.FulfilmentNearest }
+
+func (NearestStrategy) Rank(candidates []models.FulfilmentCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		di, dj := candidates[i].Distance, candidates[j].Distance
+		switch {
+		case di != nil && dj != nil && *di != *dj:
+			return *di < *dj
+		case (di == nil) != (dj == nil):
+			return di != nil
+		}
+		return totalStock(candidates[i]) > totalStock(candidates[j])
+	})
+}
+
+// MostStockStrategy ships from the warehouse holding the most units of the
+// order's products, which keeps split shipments rare.
+type MostStockStrategy struct{}
+
+func (MostStockStrategy) Name() string { return models.FulfilmentMostStock }
+
+func (MostStockStrategy) Rank(candidates []models.FulfilmentCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return totalStock(candidates[i]) > totalStock(candidates[j])
+	})
+}
+
+func totalStock(c models.FulfilmentCandidate) int {
+	total := 0
+	for _, n := range c.Stock {
+		total += n
+	}
+	return total
+}
+
+// fulfilmentStrategy returns the named strategy, or ok false if unknown.
+func fulfilmentStrategy(name string) (strategy FulfilmentStrategy, ok bool) {
+	switch name {
+	case models.FulfilmentNearest:
+		return NearestStrategy{}, true
+	case models.FulfilmentMostStock:
+		return MostStockStrategy{}, true
+	}
+	return nil, false
+}
+
+// fulfilmentStrategyFromEnv reads FULFILMENT_STRATEGY, defaulting to
+// most_stock since order events carry no destination.
+func fulfilmentStrategyFromEnv() FulfilmentStrategy {
+	if strategy, ok := fulfilmentStrategy(os.Getenv("FULFILMENT_STRATEGY")); ok {
+		return strategy
+	}
+	return MostStockStrategy{}
+}
+
+// loadCandidates reads every warehouse with its stock of productIDs. Callers
+// that go on to move stock must hold the products' row locks, which guard
+// their warehouse levels too.
+func loadCandidates(q sqlx.Queryer, productIDs []string, destination *models.GeoPoint) ([]models.FulfilmentCandidate, error) {
+	rows, err := q.Queryx(
+		`SELECT w.id, w.code, w.name, w.latitude, w.longitude, w.is_default, w.created_at, ws.product_id, ws.quantity
+		 FROM product.warehouses w
+		 LEFT JOIN product.warehouse_stock ws ON ws.warehouse_id = w.id AND ws.product_id = ANY($1) AND ws.quantity > 0
+		 ORDER BY w.code`, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []models.FulfilmentCandidate
+	index := map[string]int{}
+	for rows.Next() {
+		var w models.Warehouse
+		var productID *string
+		var quantity *int
+		if err := rows.Scan(&w.ID, &w.Code, &w.Name, &w.Latitude, &w.Longitude, &w.IsDefault, &w.CreatedAt, &productID, &quantity); err != nil {
+			return nil, err
+		}
+		i, ok := index[w.ID]
+		if !ok {
+			c := models.FulfilmentCandidate{Warehouse: w, Stock: map[string]int{}}
+			if destination != nil && w.Latitude != nil && w.Longitude != nil {
+				d := haversineKm(*w.Latitude, *w.Longitude, destination.Latitude, destination.Longitude)
+				c.Distance = &d
+			}
+			i = len(candidates)
+			index[w.ID] = i
+			candidates = append(candidates, c)
+		}
+		if productID != nil {
+			candidates[i].Stock[*productID] = *quantity
+		}
+	}
+	return candidates, rows.Err()
+}
+
+// allocate assigns order lines to warehouses using strategy. It modifies the
+// candidates' stock as it allocates.
+func allocate(lines []models.ReservationItem, candidates []models.FulfilmentCandidate, strategy FulfilmentStrategy) (*models.FulfilmentPlan, error) {
+	strategy.Rank(candidates)
+	plan := &models.FulfilmentPlan{Strategy: strategy.Name(), Allocations: []models.Allocation{}}
+
+	for _, c := range candidates {
+		if canShip(c, lines) {
+			for _, line := range lines {
+				plan.Allocations = append(plan.Allocations, models.Allocation{
+					ProductID: line.ProductID, WarehouseID: c.ID, Quantity: line.Quantity,
+				})
+			}
+			return plan, nil
+		}
+	}
+
+	plan.Split = true
+	for _, line := range lines {
+		remaining := line.Quantity
+		for _, c := range candidates {
+			take := c.Stock[line.ProductID]
+			if take > remaining {
+				take = remaining
+			}
+			if take == 0 {
+				continue
+			}
+			c.Stock[line.ProductID] -= take
+			remaining -= take
+			plan.Allocations = append(plan.Allocations, models.Allocation{
+				ProductID: line.ProductID, WarehouseID: c.ID, Quantity: take,
+			})
+			if remaining == 0 {
+				break
+			}
+		}
+		if remaining > 0 {
+			return nil, errInsufficientWarehouseStock
+		}
+	}
+	return plan, nil
+}
+
+func canShip(c models.FulfilmentCandidate, lines []models.ReservationItem) bool {
+	need := map[string]int{}
+	for _, line := range lines {
+		need[line.ProductID] += line.Quantity
+	}
+	for productID, n := range need {
+		if c.Stock[productID] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// haversineKm is the great-circle distance between two points in km.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
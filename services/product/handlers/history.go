@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+const revisionColumns = `id, product_id, version, action, actor, changed_fields, snapshot, created_at`
+
+// recordRevision appends the product's current row to its history. The
+// changed fields are derived by diffing against the previous snapshot, so
+// every write path gets the same bookkeeping. It must run in the same
+// transaction as the write it records.
+func recordRevision(tx sqlx.Execer, productID, action, actor string) error {
+	_, err := tx.Exec(
+		`INSERT INTO product.product_revisions (product_id, version, action, actor, changed_fields, snapshot)
+		 SELECT p.id, p.version, $2, $3,
+			COALESCE((SELECT array_agg(n.key ORDER BY n.key)
+				FROM jsonb_each(to_jsonb(p)) n
+				LEFT JOIN jsonb_each(prev.snapshot) o ON o.key = n.key
+				WHERE o.value IS DISTINCT FROM n.value AND n.key NOT IN ('version', 'updated_at')), '{}'),
+			to_jsonb(p)
+		 FROM product.products p
+		 LEFT JOIN LATERAL (
+			SELECT snapshot FROM product.product_revisions
+			WHERE product_id = p.id ORDER BY version DESC LIMIT 1
+		 ) prev ON true
+		 WHERE p.id = $1`,
+		productID, action, actor,
+	)
+	return err
+}
+
+// actor identifies who made a change: the user in their token, or the
+// X-User-ID the client sent.
+func actor(r *http.Request) string {
+	if id := r.Header.Get("X-User-ID"); id != "" {
+		return id
+	}
+	return "anonymous"
+}
+
+// publishedRevisionClause restricts revisions to snapshots of the product
+// while it was published. Like statusExpr, a scheduled snapshot counts as
+// published once its publish_at has passed.
+const publishedRevisionClause = `(CASE WHEN snapshot->>'status' = '` + models.StatusScheduled + `'
+		AND (snapshot->>'publish_at')::timestamp <= NOW()
+	THEN '` + models.StatusPublished + `' ELSE snapshot->>'status' END) = '` + models.StatusPublished + `'`
+
+// History lists the product's revisions. Outside admin previews only
+// published products have a history, and it only shows the revisions in
+// which the product was published.
+func (h *ProductHandler) History(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	limit, offset := 50, 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 200 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v >= 0 {
+		offset = v
+	}
+
+	preview := previewing(r)
+	visible := "TRUE"
+	if !preview {
+		visible = publishedClause
+	}
+	var exists bool
+	if err := h.DB.Get(&exists, `SELECT EXISTS (SELECT 1 FROM product.products WHERE id = $1 AND `+visible+`)`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		return
+	}
+
+	if !preview {
+		visible = publishedRevisionClause
+	}
+	var revisions []models.ProductRevision
+	err := h.DB.Select(&revisions,
+		`SELECT `+revisionColumns+` FROM product.product_revisions
+		 WHERE product_id = $1 AND `+visible+` ORDER BY version DESC LIMIT $2 OFFSET $3`,
+		id, limit, offset,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product history"})
+		return
+	}
+	if revisions == nil {
+		revisions = []models.ProductRevision{}
+	}
+	writeJSON(w, http.StatusOK, revisions)
+}
+
+// getRevision serves the product snapshot for an exact version, or the one
+// in effect at an RFC 3339 as_of time (e.g. an order's creation time).
+// Outside admin previews, snapshots of the product while it was not
+// published are not found.
+func (h *ProductHandler) getRevision(w http.ResponseWriter, r *http.Request, id string) {
+	visible := "TRUE"
+	if !previewing(r) {
+		visible = publishedRevisionClause
+	}
+	var revision models.ProductRevision
+	var err error
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "version must be an integer"})
+			return
+		}
+		err = h.DB.QueryRowx(
+			`SELECT `+revisionColumns+` FROM product.product_revisions WHERE product_id = $1 AND version = $2 AND `+visible,
+			id, version,
+		).StructScan(&revision)
+	} else {
+		asOf, parseErr := time.Parse(time.RFC3339, r.URL.Query().Get("as_of"))
+		if parseErr != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "as_of must be an RFC 3339 timestamp"})
+			return
+		}
+		err = h.DB.QueryRowx(
+			`SELECT `+revisionColumns+` FROM (
+				SELECT * FROM product.product_revisions
+				WHERE product_id = $1 AND created_at <= $2 ORDER BY version DESC LIMIT 1
+			 ) product_revisions WHERE `+visible,
+			id, asOf.UTC(),
+		).StructScan(&revision)
+	}
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product revision not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product revision"})
+		return
+	}
+
+	w.Header().Set("ETag", etag(id, revision.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(revision.Snapshot)
+}
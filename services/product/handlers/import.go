@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const maxImportSize = 32 << 20
+
+// progressInterval is how many rows are processed between job progress updates.
+const progressInterval = 100
+
+// Running jobs record a heartbeat every heartbeatInterval. A job whose
+// heartbeat is older than staleJobAfter was lost with the replica running
+// it, since the file it was importing only lived in that replica's memory.
+const (
+	heartbeatInterval = 30 * time.Second
+	staleJobAfter     = 2 * time.Minute
+)
+
+type ImportHandler struct {
+	DB      *sqlx.DB
+	Channel *amqp.Channel
+}
+
+func NewImportHandler(db *sqlx.DB, ch *amqp.Channel) *ImportHandler {
+	return &ImportHandler{DB: db, Channel: ch}
+}
+
+type importRecord struct {
+	row  int
+	data models.ImportRow
+	err  error
+}
+
+// Import accepts a CSV or NDJSON catalog file and processes it in the
+// background. The response is the pending job, which can be polled via
+// GetJob.
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	format := requestFormat(r.URL.Query().Get("format"), r.Header.Get("Content-Type"))
+	if format == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "import file too large"})
+		return
+	}
+	if len(body) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "import file is empty"})
+		return
+	}
+
+	var job models.ImportJob
+	err = h.DB.QueryRowx(
+		`INSERT INTO product.import_jobs (format) VALUES ($1)
+		 RETURNING id, format, status, total_rows, processed_rows, created_rows, updated_rows, failed_rows, errors, created_at, completed_at`,
+		format,
+	).StructScan(&job)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create import job"})
+		return
+	}
+
+	go h.runImport(job.ID, format, body, actor(r))
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (h *ImportHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "jobID")
+	var job models.ImportJob
+	err := h.DB.QueryRowx(
+		`SELECT id, format, status, total_rows, processed_rows, created_rows, updated_rows, failed_rows, errors, created_at, completed_at
+		 FROM product.import_jobs WHERE id = $1`, id,
+	).StructScan(&job)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "import job not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch import job"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// Export streams the whole catalog in the same format Import accepts.
+// Bundles are left out: their stock comes from their components, so import
+// cannot set it.
+func (h *ImportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	param := r.URL.Query().Get("format")
+	format := requestFormat(param, r.Header.Get("Accept"))
+	if format == "" && param != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be csv or ndjson"})
+		return
+	}
+	if format == "" {
+		format = models.FormatCSV
+	}
+
+	rows, err := h.DB.Queryx(`SELECT id, COALESCE(sku, '') AS sku, name, COALESCE(description, '') AS description,
+			category, type_id, attributes, images, price, stock, ` + statusExpr + ` AS status
+		FROM product.products WHERE archived_at IS NULL AND NOT is_bundle ORDER BY created_at`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to export products"})
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, format))
+	if format == models.FormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	if format == models.FormatCSV {
+		cw.Write(models.ImportColumns)
+	}
+	for rows.Next() {
+		var row models.ImportRow
+		var attributes []byte
+		var images pq.StringArray
+		err := rows.Scan(&row.ID, &row.SKU, &row.Name, &row.Description,
+			&row.Category, &row.Type, &attributes, &images, &row.Price, &row.Stock, &row.Status)
+		if err != nil {
+			log.Printf("Failed to scan product for export: %v", err)
+			return
+		}
+		row.Attributes = attributes
+		row.Images = append([]string{}, images...)
+		if format == models.FormatCSV {
+			cw.Write([]string{
+				row.ID, row.SKU, row.Name, deref(row.Description), deref(row.Category), deref(row.Type),
+				string(row.Attributes), strings.Join(row.Images, " "),
+				strconv.FormatFloat(row.Price, 'f', 2, 64), strconv.Itoa(*row.Stock), row.Status,
+			})
+			continue
+		}
+		if err := enc.Encode(row); err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to export products: %v", err)
+	}
+}
+
+func (h *ImportHandler) runImport(jobID, format string, body []byte, actor string) {
+	h.DB.Exec(`UPDATE product.import_jobs SET status = $1, heartbeat_at = NOW() WHERE id = $2`, models.ImportStatusRunning, jobID)
+	stop := make(chan struct{})
+	defer close(stop)
+	go h.heartbeat(jobID, stop)
+
+	var records []importRecord
+	var err error
+	if format == models.FormatCSV {
+		records, err = parseCSV(body)
+	} else {
+		records, err = parseNDJSON(body)
+	}
+	if err != nil {
+		h.finishImport(jobID, models.ImportStatusFailed, 0, 0, 0, 0,
+			models.ImportRowErrors{{Row: 0, Error: err.Error()}})
+		return
+	}
+	h.DB.Exec(`UPDATE product.import_jobs SET total_rows = $1 WHERE id = $2`, len(records), jobID)
+
+	var created, updated int
+	rowErrors := models.ImportRowErrors{}
+	for i, rec := range records {
+		if rec.err == nil {
+			rec.err = validateImportRow(rec.data)
+		}
+		if rec.err == nil {
+			var before, after *models.Product
+			before, after, rec.err = h.upsertRow(rec.data, actor)
+			if rec.err == nil {
+				if before == nil {
+					created++
+					publishCatalogEvent(h.Channel, models.EventProductCreated, nil, after, actor)
+				} else {
+					updated++
+					publishCatalogEvent(h.Channel, models.EventProductUpdated, before, after, actor)
+					if before.Stock != after.Stock {
+						publishCatalogEvent(h.Channel, models.EventProductStockChanged, before, after, actor)
+					}
+				}
+			}
+		}
+		if rec.err != nil {
+			rowErrors = append(rowErrors, models.ImportRowError{Row: rec.row, SKU: rec.data.SKU, Error: rec.err.Error()})
+		}
+
+		if (i+1)%progressInterval == 0 {
+			h.DB.Exec(
+				`UPDATE product.import_jobs SET processed_rows = $1, created_rows = $2, updated_rows = $3, failed_rows = $4 WHERE id = $5`,
+				i+1, created, updated, len(rowErrors), jobID,
+			)
+		}
+	}
+
+	h.finishImport(jobID, models.ImportStatusCompleted, len(records), created, updated, len(rowErrors), rowErrors)
+	log.Printf("Import job %s finished: %d created, %d updated, %d failed", jobID, created, updated, len(rowErrors))
+}
+
+// upsertRow creates or updates the product the row names, by ID or else by
+// SKU, and records the change in the product's history. It returns the
+// product before the change, nil when it was created, and after.
+func (h *ImportHandler) upsertRow(row models.ImportRow, actor string) (*models.Product, *models.Product, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var before *models.Product
+	var existingID string
+	if row.ID != "" {
+		err = tx.QueryRow(`SELECT id FROM product.products WHERE id = $1 FOR UPDATE`, row.ID).Scan(&existingID)
+		if err == sql.ErrNoRows {
+			return nil, nil, errors.New("no product has this id")
+		}
+	} else {
+		err = tx.QueryRow(`SELECT id FROM product.products WHERE sku = $1 FOR UPDATE`, row.SKU).Scan(&existingID)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if existingID != "" {
+		if before, err = loadProduct(tx, existingID); err != nil {
+			return nil, nil, err
+		}
+		if before.IsBundle {
+			return nil, nil, errBundleStock
+		}
+	}
+
+	description, category, typeID, attributes, images := row.Description, row.Category, row.Type, row.Attributes, row.Images
+	stock := 0
+	if before != nil {
+		stock = before.Stock
+		if description == nil {
+			description = &before.Description
+		}
+		if category == nil {
+			category = before.Category
+		}
+		if typeID == nil {
+			typeID = before.Type
+		}
+		if attributes == nil {
+			attributes = before.Attributes
+		}
+		if images == nil {
+			images = before.Images
+		}
+	}
+	if row.Stock != nil {
+		stock = *row.Stock
+	}
+	if category != nil && *category == "" {
+		category = nil
+	}
+	if typeID != nil && *typeID == "" {
+		typeID = nil
+	}
+	if len(attributes) == 0 || string(attributes) == "null" {
+		attributes = json.RawMessage("{}")
+	}
+	fieldErrs, err := validateAttributes(tx, typeID, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(fieldErrs) > 0 {
+		return nil, nil, fmt.Errorf("invalid attributes: %s: %s", fieldErrs[0].Field, fieldErrs[0].Message)
+	}
+	if err := ensureCategory(tx, category); err != nil {
+		return nil, nil, err
+	}
+
+	var current *string
+	if before != nil {
+		current = before.Slug
+	}
+	slug, err := assignSlug(tx, existingID, nil, current, row.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A row without a SKU keeps the product's current one.
+	var sku *string
+	if row.SKU != "" {
+		sku = &row.SKU
+	} else if before != nil {
+		sku = before.SKU
+	}
+	id := existingID
+	if before == nil {
+		status := row.Status
+		if status == "" {
+			status = models.StatusDraft
+		}
+		err = tx.QueryRow(
+			`INSERT INTO product.products (sku, name, slug, description, category, type_id, attributes, images, price, stock,
+				status, published_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+				$11, CASE WHEN $11 = '`+models.StatusPublished+`' THEN NOW() END)
+			 RETURNING id`,
+			sku, row.Name, slug, deref(description), category, typeID, string(attributes), pq.Array(append([]string{}, images...)),
+			row.Price, stock, status,
+		).Scan(&id)
+	} else {
+		_, err = tx.Exec(
+			`UPDATE product.products SET sku = $1, name = $2, slug = $3, description = $4, category = $5, type_id = $6,
+				attributes = $7, images = $8, price = $9, stock = $10, version = version + 1, updated_at = NOW()
+			 WHERE id = $11`,
+			sku, row.Name, slug, deref(description), category, typeID, string(attributes), pq.Array(append([]string{}, images...)),
+			row.Price, stock, id,
+		)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	previousStock := 0
+	if before != nil {
+		previousStock = before.Stock
+	}
+	if err := adjustDefaultStock(tx, id, stock-previousStock, models.LedgerManualCount, actor, "import"); err != nil {
+		return nil, nil, err
+	}
+	if err := recordRevision(tx, id, models.RevisionImported, actor); err != nil {
+		return nil, nil, err
+	}
+	if err := recordPrice(tx, id, models.PriceReasonImported, actor); err != nil {
+		return nil, nil, err
+	}
+	after, err := loadProduct(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, tx.Commit()
+}
+
+func (h *ImportHandler) heartbeat(jobID string, stop <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.DB.Exec(`UPDATE product.import_jobs SET heartbeat_at = NOW() WHERE id = $1`, jobID)
+		}
+	}
+}
+
+// StartStaleJobReaper fails import jobs that stopped sending heartbeats,
+// which happens when the replica running them restarts, so they do not stay
+// pending or running forever.
+func (h *ImportHandler) StartStaleJobReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := h.DB.Exec(
+			`UPDATE product.import_jobs SET status = $1, errors = $2, completed_at = NOW()
+			 WHERE status IN ($3, $4) AND heartbeat_at < NOW() - $5 * INTERVAL '1 second'`,
+			models.ImportStatusFailed,
+			models.ImportRowErrors{{Row: 0, Error: "import was interrupted; upload the file again"}},
+			models.ImportStatusPending, models.ImportStatusRunning, int64(staleJobAfter.Seconds()),
+		)
+		if err != nil {
+			log.Printf("Failed to fail stale import jobs: %v", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Failed %d interrupted import jobs", n)
+		}
+	}
+}
+
+func (h *ImportHandler) finishImport(jobID, status string, processed, created, updated, failed int, rowErrors models.ImportRowErrors) {
+	_, err := h.DB.Exec(
+		`UPDATE product.import_jobs SET status = $1, processed_rows = $2, created_rows = $3, updated_rows = $4,
+			failed_rows = $5, errors = $6, completed_at = NOW()
+		 WHERE id = $7`,
+		status, processed, created, updated, failed, rowErrors, jobID,
+	)
+	if err != nil {
+		log.Printf("Failed to update import job %s: %v", jobID, err)
+	}
+}
+
+func parseCSV(body []byte) ([]importRecord, error) {
+	cr := csv.NewReader(bytes.NewReader(body))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	index := map[string]int{}
+	for i, col := range header {
+		index[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, col := range []string{"name", "price"} {
+		if _, ok := index[col]; !ok {
+			return nil, fmt.Errorf("CSV header is missing required column %q", col)
+		}
+	}
+	_, hasID := index["id"]
+	if _, ok := index["sku"]; !ok && !hasID {
+		return nil, errors.New(`CSV header must have an "id" or "sku" column`)
+	}
+
+	field := func(rec []string, col string) string {
+		i, ok := index[col]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	// optional returns nil for columns the file does not have, so that
+	// the product keeps its current value.
+	optional := func(rec []string, col string) *string {
+		if _, ok := index[col]; !ok {
+			return nil
+		}
+		v := field(rec, col)
+		return &v
+	}
+
+	var records []importRecord
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			records = append(records, importRecord{row: row, err: err})
+			continue
+		}
+
+		data := models.ImportRow{
+			ID:          field(rec, "id"),
+			SKU:         field(rec, "sku"),
+			Name:        field(rec, "name"),
+			Description: optional(rec, "description"),
+			Category:    optional(rec, "category"),
+			Type:        optional(rec, "type"),
+			Status:      field(rec, "status"),
+		}
+		if v := optional(rec, "attributes"); v != nil {
+			data.Attributes = json.RawMessage("{}")
+			if *v != "" {
+				data.Attributes = json.RawMessage(*v)
+			}
+		}
+		if v := optional(rec, "images"); v != nil {
+			data.Images = append([]string{}, strings.Fields(*v)...)
+		}
+		var parseErr error
+		if len(data.Attributes) > 0 && !json.Valid(data.Attributes) {
+			parseErr = errors.New("attributes is not valid JSON")
+		}
+		if data.Price, err = strconv.ParseFloat(field(rec, "price"), 64); err != nil && parseErr == nil {
+			parseErr = errors.New("price is not a number")
+		}
+		if s := optional(rec, "stock"); s != nil && *s != "" && parseErr == nil {
+			stock, err := strconv.Atoi(*s)
+			if err != nil {
+				parseErr = errors.New("stock is not an integer")
+			}
+			data.Stock = &stock
+		}
+		records = append(records, importRecord{row: row, data: data, err: parseErr})
+	}
+	return records, nil
+}
+
+// parseNDJSON reads one record per line. A line that cannot be read at all
+// fails the whole file rather than silently truncating the import.
+func parseNDJSON(body []byte) ([]importRecord, error) {
+	var records []importRecord
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), maxImportSize)
+	for row := 1; scanner.Scan(); row++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			row--
+			continue
+		}
+		var data models.ImportRow
+		var err error
+		if err = json.Unmarshal(line, &data); err != nil {
+			err = errors.New("invalid JSON")
+		}
+		records = append(records, importRecord{row: row, data: data, err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read NDJSON line %d: %w", len(records)+1, err)
+	}
+	return records, nil
+}
+
+func validateImportRow(row models.ImportRow) error {
+	switch {
+	case row.ID != "" && !productID.MatchString(row.ID):
+		return errors.New("id is not a product ID")
+	case row.SKU == "" && row.ID == "":
+		return errors.New("sku is required")
+	case len(row.SKU) > 64:
+		return errors.New("sku must be at most 64 characters")
+	case row.Name == "":
+		return errors.New("name is required")
+	case row.Price <= 0:
+		return errors.New("price must be positive")
+	case row.Stock != nil && *row.Stock < 0:
+		return errors.New("stock cannot be negative")
+	case row.Status != "" && row.Status != models.StatusDraft && row.Status != models.StatusPublished &&
+		row.Status != models.StatusUnpublished:
+		return errors.New("status must be draft, published or unpublished")
+	}
+	return validateImages(row.Images)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// requestFormat resolves an explicit format parameter or a media type to one
+// of the supported catalog file formats.
+func requestFormat(param, mediaType string) string {
+	switch strings.ToLower(param) {
+	case models.FormatCSV, models.FormatNDJSON:
+		return strings.ToLower(param)
+	case "":
+	default:
+		return ""
+	}
+	switch {
+	case strings.Contains(mediaType, "text/csv"):
+		return models.FormatCSV
+	case strings.Contains(mediaType, "ndjson"), strings.Contains(mediaType, "jsonlines"):
+		return models.FormatNDJSON
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ecommerce/product/models"
+)
+
+func TestParseCSVLeavesAbsentColumnsUnset(t *testing.T) {
+	records, err := parseCSV([]byte("sku,name,price\nABC-1,Widget,9.99\n"))
+	if err != nil {
+		t.Fatalf("parseCSV: %v", err)
+	}
+	if len(records) != 1 || records[0].err != nil {
+		t.Fatalf("records = %+v, want one valid record", records)
+	}
+	row := records[0].data
+	if row.Description != nil || row.Stock != nil || row.Category != nil || row.Type != nil ||
+		row.Attributes != nil || row.Images != nil {
+		t.Errorf("absent columns were set: %+v", row)
+	}
+	if row.SKU != "ABC-1" || row.Name != "Widget" || row.Price != 9.99 {
+		t.Errorf("row = %+v", row)
+	}
+}
+
+func TestParseCSVReadsPresentColumns(t *testing.T) {
+	body := "id,sku,name,description,category,type,attributes,images,price,stock,status\n" +
+		`,ABC-1,Widget,,tools,,"{""color"":""red""}",a.png b.png,9.99,0,published` + "\n"
+	records, err := parseCSV([]byte(body))
+	if err != nil {
+		t.Fatalf("parseCSV: %v", err)
+	}
+	if records[0].err != nil {
+		t.Fatalf("row error: %v", records[0].err)
+	}
+	row := records[0].data
+	// An empty description cell clears the description; a zero stock cell
+	// sets the stock to zero.
+	if row.Description == nil || *row.Description != "" {
+		t.Errorf("Description = %v, want empty", row.Description)
+	}
+	if row.Stock == nil || *row.Stock != 0 {
+		t.Errorf("Stock = %v, want 0", row.Stock)
+	}
+	if row.Category == nil || *row.Category != "tools" {
+		t.Errorf("Category = %v, want tools", row.Category)
+	}
+	if row.Type == nil || *row.Type != "" {
+		t.Errorf("Type = %v, want empty", row.Type)
+	}
+	if string(row.Attributes) != `{"color":"red"}` {
+		t.Errorf("Attributes = %s", row.Attributes)
+	}
+	if strings.Join(row.Images, " ") != "a.png b.png" {
+		t.Errorf("Images = %v", row.Images)
+	}
+	if row.Status != "published" {
+		t.Errorf("Status = %q", row.Status)
+	}
+}
+
+func TestParseCSVRowErrors(t *testing.T) {
+	body := "sku,name,price,stock,attributes\n" +
+		"A,Widget,abc,1,\n" +
+		"B,Widget,1,x,\n" +
+		"C,Widget,1,1,{bad\n"
+	records, err := parseCSV([]byte(body))
+	if err != nil {
+		t.Fatalf("parseCSV: %v", err)
+	}
+	want := []string{"price is not a number", "stock is not an integer", "attributes is not valid JSON"}
+	for i, w := range want {
+		if records[i].err == nil || records[i].err.Error() != w {
+			t.Errorf("row %d error = %v, want %q", i+1, records[i].err, w)
+		}
+	}
+}
+
+func TestParseCSVRequiresKeyColumn(t *testing.T) {
+	if _, err := parseCSV([]byte("name,price\nWidget,1\n")); err == nil {
+		t.Error("parseCSV accepted a file with neither id nor sku")
+	}
+}
+
+func TestParseNDJSONLeavesAbsentFieldsUnset(t *testing.T) {
+	body := `{"sku":"A","name":"Widget","price":1}` + "\n\n" + `{"sku":"B","name":"Gadget","price":2,"stock":3}` + "\nnot json\n"
+	records, err := parseNDJSON([]byte(body))
+	if err != nil {
+		t.Fatalf("parseNDJSON: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	if records[0].data.Stock != nil || records[0].data.Description != nil {
+		t.Errorf("absent fields were set: %+v", records[0].data)
+	}
+	if s := records[1].data.Stock; s == nil || *s != 3 {
+		t.Errorf("Stock = %v, want 3", s)
+	}
+	if records[2].row != 3 || records[2].err == nil {
+		t.Errorf("record 3 = %+v, want an invalid JSON error on row 3", records[2])
+	}
+}
+
+func TestParseNDJSONFailsOnOverlongLine(t *testing.T) {
+	body := `{"sku":"` + strings.Repeat("a", maxImportSize) + `"}`
+	if _, err := parseNDJSON([]byte(body)); err == nil {
+		t.Error("parseNDJSON accepted a line longer than the import limit")
+	}
+}
+
+func TestValidateImportRow(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name    string
+		row     models.ImportRow
+		wantErr bool
+	}{
+		{"valid", models.ImportRow{SKU: "A", Name: "Widget", Price: 1}, false},
+		{"by id", models.ImportRow{ID: "6f1c2a4e-8d3b-4f5a-9c7e-0b1d2e3f4a5b", Name: "Widget", Price: 1}, false},
+		{"missing sku", models.ImportRow{Name: "Widget", Price: 1}, true},
+		{"bad id", models.ImportRow{ID: "42", Name: "Widget", Price: 1}, true},
+		{"missing name", models.ImportRow{SKU: "A", Price: 1}, true},
+		{"free", models.ImportRow{SKU: "A", Name: "Widget"}, true},
+		{"negative stock", models.ImportRow{SKU: "A", Name: "Widget", Price: 1, Stock: &negative}, true},
+		{"archived status", models.ImportRow{SKU: "A", Name: "Widget", Price: 1, Status: "archived"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateImportRow(tt.row); (err != nil) != tt.wantErr {
+				t.Errorf("validateImportRow = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
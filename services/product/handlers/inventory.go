@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	ledgerColumns     = `id, product_id, warehouse_id, delta, quantity_after, reason, actor, reference, note, created_at`
+	cycleCountColumns = `id, product_id, warehouse_id, expected, counted, discrepancy, actor, reference, note, created_at`
+)
+
+var (
+	errStockBelowZero        = errors.New("stock cannot go below zero in that warehouse")
+	errUnknownWarehouse      = errors.New("warehouse not found")
+	errDefaultWarehouseShort = errors.New("the default warehouse does not hold enough stock; adjust warehouse levels instead")
+	errBundleStock           = errors.New("bundles hold no stock of their own; adjust their components instead")
+)
+
+// InventoryHandler serves the inventory ledger. Every change to a warehouse
+// level goes through recordMovement, so the ledger alone explains current
+// stock.
+type InventoryHandler struct {
+	DB      *sqlx.DB
+	Channel *amqp.Channel
+}
+
+func NewInventoryHandler(db *sqlx.DB, ch *amqp.Channel) *InventoryHandler {
+	return &InventoryHandler{DB: db, Channel: ch}
+}
+
+// Adjust records a receipt, sale, return or damage for the product.
+func (h *InventoryHandler) Adjust(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	var req models.AdjustStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	switch {
+	case req.Reason == models.LedgerReceipt || req.Reason == models.LedgerReturn:
+		if req.Delta <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": req.Reason + " delta must be positive"})
+			return
+		}
+	case req.Reason == models.LedgerSale || req.Reason == models.LedgerDamage:
+		if req.Delta >= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": req.Reason + " delta must be negative"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reason must be receipt, sale, return or damage; use cycle counts for manual counts"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, productID)
+	if !ok {
+		return
+	}
+	warehouseID, ok := resolveWarehouse(w, tx, req.WarehouseID)
+	if !ok {
+		return
+	}
+	entry, err := recordMovement(tx, models.LedgerEntry{
+		ProductID: productID, WarehouseID: warehouseID, Delta: req.Delta,
+		Reason: req.Reason, Actor: actor(r), Reference: req.Reference, Note: req.Note,
+	})
+	if err != nil {
+		writeMovementError(w, err)
+		return
+	}
+	if !h.commitMovement(w, tx, before, actor(r)) {
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+// CycleCount records a physical count and, if it differs from the expected
+// level, a manual_count ledger entry that corrects the level to the count.
+func (h *InventoryHandler) CycleCount(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	var req models.CycleCountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Counted < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "non-negative counted quantity is required"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, productID)
+	if !ok {
+		return
+	}
+	warehouseID, ok := resolveWarehouse(w, tx, req.WarehouseID)
+	if !ok {
+		return
+	}
+
+	var count models.CycleCount
+	err = tx.QueryRowx(
+		`INSERT INTO product.cycle_counts (product_id, warehouse_id, expected, counted, actor, reference, note)
+		 SELECT $1, $2, COALESCE((SELECT quantity FROM product.warehouse_stock WHERE product_id = $1 AND warehouse_id = $2), 0),
+			$3, $4, $5, $6
+		 RETURNING `+cycleCountColumns,
+		productID, warehouseID, req.Counted, actor(r), req.Reference, req.Note,
+	).StructScan(&count)
+	if pqErr, ok := err.(*pq.Error); ok && (pqErr.Code == "23503" || pqErr.Code == "22P02") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errUnknownWarehouse.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record cycle count"})
+		return
+	}
+	_, err = recordMovement(tx, models.LedgerEntry{
+		ProductID: productID, WarehouseID: warehouseID, Delta: count.Discrepancy,
+		Reason: models.LedgerManualCount, Actor: actor(r),
+		Reference: "cycle-count:" + strconv.FormatInt(count.ID, 10), Note: req.Note,
+	})
+	if err != nil {
+		writeMovementError(w, err)
+		return
+	}
+	if !h.commitMovement(w, tx, before, actor(r)) {
+		return
+	}
+	writeJSON(w, http.StatusCreated, count)
+}
+
+// ListCycleCounts returns cycle counts, newest first, filtered by
+// ?product_id=, ?warehouse_id= and ?discrepancies=true.
+func (h *InventoryHandler) ListCycleCounts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where, args := ledgerFilters(q)
+	if q.Get("discrepancies") == "true" {
+		where = append(where, "discrepancy <> 0")
+	}
+	var counts []models.CycleCount
+	err := h.DB.Select(&counts,
+		`SELECT `+cycleCountColumns+` FROM product.cycle_counts WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY id DESC LIMIT 200`, args...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch cycle counts"})
+		return
+	}
+	if counts == nil {
+		counts = []models.CycleCount{}
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
+
+// Ledger queries the inventory ledger, newest first. It filters by
+// ?product_id=, ?warehouse_id=, ?reason=, ?reference= and an RFC 3339
+// ?since=/?until= range, and pages with limit/offset; the total is sent in
+// X-Total-Count.
+func (h *InventoryHandler) Ledger(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where, args := ledgerFilters(q)
+	for _, f := range []struct{ param, column string }{{"reason", "reason"}, {"reference", "reference"}} {
+		if v := q.Get(f.param); v != "" {
+			args = append(args, v)
+			where = append(where, f.column+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		if v := q.Get(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": f.param + " must be an RFC 3339 timestamp"})
+				return
+			}
+			args = append(args, t.UTC())
+			where = append(where, "created_at "+f.op+" $"+strconv.Itoa(len(args)))
+		}
+	}
+	limit, offset := 50, 0
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v <= 500 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v >= 0 {
+		offset = v
+	}
+	filter := strings.Join(where, " AND ")
+
+	var total int
+	if err := h.DB.Get(&total, `SELECT COUNT(*) FROM product.inventory_ledger WHERE `+filter, args...); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch ledger"})
+		return
+	}
+	var entries []models.LedgerEntry
+	err := h.DB.Select(&entries,
+		`SELECT `+ledgerColumns+` FROM product.inventory_ledger WHERE `+filter+`
+		 ORDER BY id DESC LIMIT `+strconv.Itoa(limit)+` OFFSET `+strconv.Itoa(offset), args...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch ledger"})
+		return
+	}
+	if entries == nil {
+		entries = []models.LedgerEntry{}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// Reconcile lists warehouse levels and product totals that disagree with the
+// sum of their ledger entries. An empty list means stock is fully explained.
+func (h *InventoryHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	var issues []models.ReconciliationIssue
+	err := h.DB.Select(&issues,
+		`WITH ledger AS (
+			SELECT product_id, warehouse_id, SUM(delta) AS quantity
+			FROM product.inventory_ledger GROUP BY product_id, warehouse_id
+		 )
+		 SELECT COALESCE(ws.product_id, l.product_id) AS product_id,
+			COALESCE(ws.warehouse_id, l.warehouse_id)::text AS warehouse_id,
+			COALESCE(ws.quantity, 0) AS recorded, COALESCE(l.quantity, 0) AS ledger
+		 FROM product.warehouse_stock ws
+		 FULL JOIN ledger l ON l.product_id = ws.product_id AND l.warehouse_id = ws.warehouse_id
+		 WHERE COALESCE(ws.quantity, 0) <> COALESCE(l.quantity, 0)
+		 UNION ALL
+		 SELECT p.id, NULL, p.stock, COALESCE((SELECT SUM(delta) FROM product.inventory_ledger WHERE product_id = p.id), 0)
+		 FROM product.products p
+		 WHERE p.stock <> COALESCE((SELECT SUM(delta) FROM product.inventory_ledger WHERE product_id = p.id), 0)
+		 ORDER BY product_id, warehouse_id NULLS FIRST`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reconcile stock"})
+		return
+	}
+	if issues == nil {
+		issues = []models.ReconciliationIssue{}
+	}
+	writeJSON(w, http.StatusOK, issues)
+}
+
+// Backfill records an opening balance in the default warehouse for products
+// whose stock predates the ledger, so Reconcile starts from a clean slate.
+// Products that already have warehouse levels or ledger entries are left to
+// Reconcile.
+func (h *InventoryHandler) Backfill() {
+	var ids []string
+	err := h.DB.Select(&ids,
+		`SELECT p.id FROM product.products p
+		 WHERE p.stock > 0
+		   AND NOT EXISTS (SELECT 1 FROM product.bundle_components WHERE bundle_id = p.id)
+		   AND NOT EXISTS (SELECT 1 FROM product.warehouse_stock WHERE product_id = p.id)
+		   AND NOT EXISTS (SELECT 1 FROM product.inventory_ledger WHERE product_id = p.id)
+		 ORDER BY p.created_at`)
+	if err != nil {
+		log.Printf("Failed to fetch products without opening balances: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if err := h.openBalance(id); err != nil {
+			log.Printf("Failed to record opening balance for product %s: %v", id, err)
+		}
+	}
+}
+
+func (h *InventoryHandler) openBalance(productID string) error {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Re-check under the product lock; a movement may have landed since.
+	var stock int
+	err = tx.Get(&stock,
+		`SELECT stock FROM product.products p WHERE id = $1
+		   AND NOT EXISTS (SELECT 1 FROM product.warehouse_stock WHERE product_id = p.id)
+		   AND NOT EXISTS (SELECT 1 FROM product.inventory_ledger WHERE product_id = p.id)
+		 FOR UPDATE`, productID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	warehouseID, err := defaultWarehouseID(tx)
+	if err != nil {
+		return err
+	}
+	_, err = recordMovement(tx, models.LedgerEntry{
+		ProductID: productID, WarehouseID: warehouseID, Delta: stock,
+		Reason: models.LedgerOpeningBalance, Actor: "system", Reference: "opening balance",
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// commitMovement brings the product's total stock in line with its warehouse
+// levels, commits and announces the change. It writes the error response and
+// returns false on failure.
+func (h *InventoryHandler) commitMovement(w http.ResponseWriter, tx *sqlx.Tx, before *models.Product, actor string) bool {
+	after, err := syncProductStock(tx, before.ID, actor)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update product stock"})
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return false
+	}
+	if after.Stock != before.Stock {
+		publishCatalogEvent(h.Channel, models.EventProductStockChanged, before, after, actor)
+	}
+	return true
+}
+
+// recordMovement applies entry.Delta to the product's level in
+// entry.WarehouseID and appends it to the ledger. It leaves the product's
+// total stock alone; callers keep that in step. A zero delta records nothing.
+func recordMovement(tx *sqlx.Tx, entry models.LedgerEntry) (*models.LedgerEntry, error) {
+	if entry.Delta == 0 {
+		return nil, nil
+	}
+	var isBundle bool
+	err := tx.Get(&isBundle, `SELECT EXISTS (SELECT 1 FROM product.bundle_components WHERE bundle_id = $1)`, entry.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if isBundle {
+		return nil, errBundleStock
+	}
+	var recorded models.LedgerEntry
+	err = tx.QueryRowx(
+		`WITH level AS (
+			INSERT INTO product.warehouse_stock (warehouse_id, product_id, quantity) VALUES ($1, $2, $3)
+			ON CONFLICT (warehouse_id, product_id) DO UPDATE
+				SET quantity = warehouse_stock.quantity + EXCLUDED.quantity, updated_at = NOW()
+			RETURNING quantity
+		 )
+		 INSERT INTO product.inventory_ledger (product_id, warehouse_id, delta, quantity_after, reason, actor, reference, note)
+		 SELECT $2, $1, $3, quantity, $4, $5, $6, $7 FROM level
+		 RETURNING `+ledgerColumns,
+		entry.WarehouseID, entry.ProductID, entry.Delta, entry.Reason, entry.Actor, entry.Reference, entry.Note,
+	).StructScan(&recorded)
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23514":
+			return nil, errStockBelowZero
+		case "23503", "22P02":
+			return nil, errUnknownWarehouse
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &recorded, nil
+}
+
+// adjustDefaultStock records a product-level stock change against the
+// default warehouse, keeping the product's stock equal to the sum of its
+// levels.
+func adjustDefaultStock(tx *sqlx.Tx, productID string, delta int, reason, actor, reference string) error {
+	if delta == 0 {
+		return nil
+	}
+	warehouseID, err := defaultWarehouseID(tx)
+	if err != nil {
+		return err
+	}
+	_, err = recordMovement(tx, models.LedgerEntry{
+		ProductID: productID, WarehouseID: warehouseID, Delta: delta,
+		Reason: reason, Actor: actor, Reference: reference,
+	})
+	if err == errStockBelowZero {
+		return errDefaultWarehouseShort
+	}
+	return err
+}
+
+// syncProductStock recomputes the product's total stock from its warehouse
+// levels as a new revision and returns the resulting snapshot.
+func syncProductStock(tx *sqlx.Tx, productID, actor string) (*models.Product, error) {
+	_, err := tx.Exec(
+		`UPDATE product.products SET
+			stock = (SELECT COALESCE(SUM(quantity), 0) FROM product.warehouse_stock WHERE product_id = $1),
+			version = version + 1, updated_at = NOW()
+		 WHERE id = $1`, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordRevision(tx, productID, models.RevisionAdjusted, actor); err != nil {
+		return nil, err
+	}
+	return loadProduct(tx, productID)
+}
+
+func defaultWarehouseID(q sqlx.Queryer) (string, error) {
+	var id string
+	err := q.QueryRowx(`SELECT id FROM product.warehouses WHERE is_default`).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errors.New("no default warehouse configured")
+	}
+	return id, err
+}
+
+// resolveWarehouse returns the requested warehouse, or the default one when
+// none is given. It writes the error response and returns false on failure.
+func resolveWarehouse(w http.ResponseWriter, q sqlx.Queryer, requested string) (string, bool) {
+	if requested != "" {
+		return requested, true
+	}
+	id, err := defaultWarehouseID(q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return "", false
+	}
+	return id, true
+}
+
+// ledgerFilters builds conditions for ?product_id= and ?warehouse_id=.
+func ledgerFilters(q url.Values) ([]string, []interface{}) {
+	where := []string{"true"}
+	var args []interface{}
+	for _, param := range []string{"product_id", "warehouse_id"} {
+		if v := q.Get(param); v != "" {
+			args = append(args, v)
+			where = append(where, param+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	return where, args
+}
+
+func writeMovementError(w http.ResponseWriter, err error) {
+	switch err {
+	case errStockBelowZero, errDefaultWarehouseShort, errBundleStock:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errUnknownWarehouse:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record stock movement"})
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// The pricing expressions below are evaluated against a product.products row
+// and work in SELECTs as well as RETURNING clauses. A base price schedule that
+// is due but not yet applied by the scheduler already counts, so reads never
+// depend on scheduler lag.
+const (
+	activeSaleClause = `FROM product.price_schedules s
+		WHERE s.product_id = products.id AND s.kind = 'sale' AND s.cancelled_at IS NULL
+			AND s.starts_at <= NOW() AND s.ends_at > NOW()
+		ORDER BY s.starts_at DESC LIMIT 1`
+
+	regularPriceExpr = `COALESCE((SELECT s.price FROM product.price_schedules s
+		WHERE s.product_id = products.id AND s.kind = 'price' AND s.cancelled_at IS NULL
+			AND s.applied_at IS NULL AND s.starts_at <= NOW()
+		ORDER BY s.starts_at DESC LIMIT 1), products.price)`
+
+	effectivePriceExpr = `COALESCE((SELECT s.price ` + activeSaleClause + `), ` + regularPriceExpr + `)`
+
+	compareAtPriceExpr = `(SELECT COALESCE(s.compare_at_price, ` + regularPriceExpr + `) ` + activeSaleClause + `)`
+)
+
+const scheduleColumns = `id, product_id, kind, price, compare_at_price, starts_at, ends_at,
+	applied_at, started_at, ended_at, cancelled_at, created_by, created_at`
+
+type PricingHandler struct {
+	DB      *sqlx.DB
+	Channel *amqp.Channel
+}
+
+func NewPricingHandler(db *sqlx.DB, ch *amqp.Channel) *PricingHandler {
+	return &PricingHandler{DB: db, Channel: ch}
+}
+
+// CreateSchedule schedules a base price change or a sale. One that starts at
+// once changes the product's price in the same transaction.
+func (h *PricingHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	var req models.CreatePriceScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	startsAt := time.Now()
+	if req.StartsAt != nil {
+		startsAt = *req.StartsAt
+	}
+	switch {
+	case req.Kind != models.ScheduleKindPrice && req.Kind != models.ScheduleKindSale:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be price or sale"})
+		return
+	case req.Price <= 0:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "positive price is required"})
+		return
+	case req.Kind == models.ScheduleKindSale && (req.EndsAt == nil || !req.EndsAt.After(startsAt)):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sales need an ends_at after starts_at"})
+		return
+	case req.Kind == models.ScheduleKindPrice && (req.EndsAt != nil || req.CompareAtPrice != nil):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "price changes cannot have ends_at or compare_at_price"})
+		return
+	case req.CompareAtPrice != nil && *req.CompareAtPrice <= req.Price:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "compare_at_price must be greater than price"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, productID)
+	if !ok {
+		return
+	}
+	if before.ArchivedAt != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		return
+	}
+	var schedule models.PriceSchedule
+	err = tx.QueryRowx(
+		`INSERT INTO product.price_schedules (product_id, kind, price, compare_at_price, starts_at, ends_at, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING `+scheduleColumns,
+		productID, req.Kind, req.Price, req.CompareAtPrice, startsAt.UTC(), utcOrNil(req.EndsAt), actor(r),
+	).StructScan(&schedule)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create price schedule"})
+		return
+	}
+	after, err := applyPriceChange(tx, before, actor(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	if after != nil {
+		publishCatalogEvent(h.Channel, models.EventProductUpdated, before, after, actor(r))
+	}
+	writeJSON(w, http.StatusCreated, schedule)
+}
+
+func (h *PricingHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	var schedules []models.PriceSchedule
+	err := h.DB.Select(&schedules,
+		`SELECT `+scheduleColumns+` FROM product.price_schedules WHERE product_id = $1 ORDER BY starts_at DESC`,
+		productID,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch price schedules"})
+		return
+	}
+	if schedules == nil {
+		schedules = []models.PriceSchedule{}
+	}
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+// CancelSchedule cancels a schedule that has not taken effect yet. A sale
+// that is already running is ended now instead, so the scheduler still
+// announces the price change; the product's version moves on right away.
+func (h *PricingHandler) CancelSchedule(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	scheduleID := chi.URLParam(r, "scheduleID")
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, productID)
+	if !ok {
+		return
+	}
+	var schedule models.PriceSchedule
+	err = tx.QueryRowx(
+		`UPDATE product.price_schedules SET
+			cancelled_at = CASE WHEN starts_at > NOW() THEN NOW() END,
+			ends_at = CASE WHEN starts_at <= NOW() THEN NOW() ELSE ends_at END
+		 WHERE id = $1 AND product_id = $2 AND cancelled_at IS NULL AND applied_at IS NULL AND ended_at IS NULL
+			AND (kind = 'sale' OR starts_at > NOW()) AND (ends_at IS NULL OR ends_at > NOW())
+		 RETURNING `+scheduleColumns,
+		scheduleID, productID,
+	).StructScan(&schedule)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no pending price schedule found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to cancel price schedule"})
+		return
+	}
+	after, err := applyPriceChange(tx, before, actor(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	if after != nil {
+		publishCatalogEvent(h.Channel, models.EventProductUpdated, before, after, actor(r))
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+// applyPriceChange handles a schedule change that alters the product's price
+// at once, such as a sale starting now or a running sale being ended: it
+// bumps the version, so ETags and cached reads move on, and records the
+// revision and price. It returns the product afterwards, or nil when the
+// price did not change. before must have been read under lock in tx.
+func applyPriceChange(tx *sqlx.Tx, before *models.Product, actor string) (*models.Product, error) {
+	current, err := loadProduct(tx, before.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Price == before.Price && current.RegularPrice == before.RegularPrice &&
+		sameCompareAt(current.CompareAtPrice, before.CompareAtPrice) {
+		return nil, nil
+	}
+	_, err = tx.Exec(`UPDATE product.products SET version = version + 1, updated_at = NOW() WHERE id = $1`, before.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordRevision(tx, before.ID, models.RevisionUpdated, actor); err != nil {
+		return nil, err
+	}
+	if err := recordPrice(tx, before.ID, models.PriceReasonUpdated, actor); err != nil {
+		return nil, err
+	}
+	return loadProduct(tx, before.ID)
+}
+
+func sameCompareAt(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (h *PricingHandler) History(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	var entries []models.PriceHistoryEntry
+	err := h.DB.Select(&entries,
+		`SELECT id, product_id, price, compare_at_price, reason, actor, created_at
+		 FROM product.price_history WHERE product_id = $1 ORDER BY id DESC`,
+		productID,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch price history"})
+		return
+	}
+	if entries == nil {
+		entries = []models.PriceHistoryEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// StartScheduler periodically applies due price changes and starts and ends
+// sales, publishing product.price_changed for each transition
+func (h *PricingHandler) StartScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.runTransition(models.PriceReasonScheduled,
+			`kind = 'price' AND applied_at IS NULL AND starts_at <= NOW()`,
+			`applied_at = NOW()`)
+		h.runTransition(models.PriceReasonSaleStarted,
+			`kind = 'sale' AND started_at IS NULL AND starts_at <= NOW() AND ends_at > NOW()`,
+			`started_at = NOW()`)
+		h.runTransition(models.PriceReasonSaleEnded,
+			`kind = 'sale' AND ended_at IS NULL AND ends_at <= NOW()`,
+			`ended_at = NOW()`)
+	}
+}
+
+// runTransition claims the schedules matching where, marks them with set and
+// records the resulting price. SKIP LOCKED lets every replica run the
+// scheduler without handling the same schedule twice.
+func (h *PricingHandler) runTransition(reason, where, set string) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		log.Printf("Failed to start price schedule transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var due []models.PriceSchedule
+	err = tx.Select(&due,
+		`SELECT `+scheduleColumns+` FROM product.price_schedules
+		 WHERE cancelled_at IS NULL AND `+where+`
+		 ORDER BY starts_at LIMIT 100 FOR UPDATE SKIP LOCKED`)
+	if err != nil {
+		log.Printf("Failed to fetch due price schedules: %v", err)
+		return
+	}
+
+	events := make([]models.PriceChangedEvent, 0, len(due))
+	for _, schedule := range due {
+		if reason == models.PriceReasonScheduled {
+			_, err = tx.Exec(
+				`UPDATE product.products SET price = $1, version = version + 1, updated_at = NOW() WHERE id = $2`,
+				schedule.Price, schedule.ProductID)
+		} else {
+			_, err = tx.Exec(
+				`UPDATE product.products SET version = version + 1, updated_at = NOW() WHERE id = $1`,
+				schedule.ProductID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`UPDATE product.price_schedules SET `+set+` WHERE id = $1`, schedule.ID)
+		}
+		if err == nil {
+			err = recordRevision(tx, schedule.ProductID, reason, "scheduler")
+		}
+		if err == nil {
+			err = recordPrice(tx, schedule.ProductID, reason, "scheduler")
+		}
+		event := models.PriceChangedEvent{ProductID: schedule.ProductID, ScheduleID: schedule.ID, Reason: reason, ChangedAt: time.Now()}
+		if err == nil {
+			err = tx.QueryRow(
+				`SELECT `+effectivePriceExpr+`, `+regularPriceExpr+`, `+compareAtPriceExpr+`
+				 FROM product.products WHERE id = $1`, schedule.ProductID,
+			).Scan(&event.Price, &event.RegularPrice, &event.CompareAtPrice)
+		}
+		if err != nil {
+			log.Printf("Failed to apply price schedule %s: %v", schedule.ID, err)
+			return
+		}
+		events = append(events, event)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit price schedules: %v", err)
+		return
+	}
+	for _, event := range events {
+		publishEvent(h.Channel, models.EventProductPriceChanged, event)
+	}
+}
+
+// recordPrice appends the product's current effective price to its price
+// history, unless it is unchanged from the latest entry.
+func recordPrice(tx sqlx.Execer, productID, reason, actor string) error {
+	_, err := tx.Exec(
+		`INSERT INTO product.price_history (product_id, price, compare_at_price, reason, actor)
+		 SELECT id, `+effectivePriceExpr+`, `+compareAtPriceExpr+`, $2, $3
+		 FROM product.products WHERE id = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM (
+					SELECT price, compare_at_price FROM product.price_history
+					WHERE product_id = $1 ORDER BY id DESC LIMIT 1
+				) latest
+				WHERE latest.price = `+effectivePriceExpr+`
+					AND latest.compare_at_price IS NOT DISTINCT FROM `+compareAtPriceExpr+`
+			)`,
+		productID, reason, actor,
+	)
+	return err
+}
+
+func utcOrNil(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
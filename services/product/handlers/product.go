@@ -3,49 +3,180 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/ecommerce/product/cache"
 	"github.com/ecommerce/product/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// productColumns is usable both in SELECTs and in RETURNING clauses. The
+// price column is the effective price at request time (see pricing.go),
+// available is stock minus units held by unexpired reservations, bundles take
+// their stock from their components (see bundle.go), and the rating columns
+// aggregate approved reviews. status reads scheduled products as published
+// once due (see publishing.go).
+const productColumns = `id, sku, name, slug, description, category, type_id, attributes,
+	` + effectivePriceExpr + ` AS price,
+	` + regularPriceExpr + ` AS regular_price,
+	` + compareAtPriceExpr + ` AS compare_at_price,
+	` + stockExpr + ` AS stock, reorder_threshold, version,
+	` + availableExpr + ` AS available,
+	images, ` + isBundleExpr + ` AS is_bundle,
+	` + statusExpr + ` AS status, publish_at, published_at,
+	` + ratingAverageExpr + ` AS rating_average,
+	` + ratingCountExpr + ` AS rating_count,
+	archived_at, created_at, updated_at`
+
+// availableExpr is stock not held by an unexpired reservation; for a bundle
+// it is how many complete bundles its components' available stock makes up.
+const availableExpr = `CASE WHEN ` + isBundleExpr + ` THEN ` + bundleAvailableExpr + `
+	ELSE stock - COALESCE((SELECT SUM(r.quantity) FROM product.reservations r
+		WHERE r.product_id = products.id AND r.status = 'active' AND r.expires_at > NOW()), 0) END`
+
+// stockExpr is the stored stock, or the number of complete bundles the
+// components' stock makes up. Bundles never hold stock of their own.
+const stockExpr = `CASE WHEN ` + isBundleExpr + ` THEN ` + bundleStockExpr + ` ELSE stock END`
+
+// maxImages caps the images a product carries; feeds take the first as the
+// main image and up to ten more.
+const maxImages = 11
+
+// ProductHandler serves the catalog. List and Get go through Cache when it is
+// set; see cache.go.
 type ProductHandler struct {
-	DB *sqlx.DB
+	DB           *sqlx.DB
+	Channel      *amqp.Channel
+	Currencies   *CurrencyHandler
+	Translations *TranslationHandler
+	Cache        *cache.ReadThrough
 }
 
-func NewProductHandler(db *sqlx.DB) *ProductHandler {
-	return &ProductHandler{DB: db}
+func NewProductHandler(db *sqlx.DB, ch *amqp.Channel, currencies *CurrencyHandler, translations *TranslationHandler, c *cache.ReadThrough) *ProductHandler {
+	return &ProductHandler{DB: db, Channel: ch, Currencies: currencies, Translations: translations, Cache: c}
 }
 
 func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
-	var products []models.Product
-	err := h.DB.Select(&products, `SELECT id, name, description, price, stock, created_at, updated_at FROM product.products ORDER BY created_at DESC`)
+	currency, ok := h.Currencies.requestCurrency(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be an ISO 4217 code"})
+		return
+	}
+	locales, ok := h.Translations.requestLocales(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag such as fr or fr-CA"})
+		return
+	}
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+	preview := previewing(r)
+	body, hit, err := h.cached(r, "list", cacheKey("list:", r, locales), func() (interface{}, error) {
+		conds, args := attributeFilters(r.URL.Query(), []interface{}{includeArchived})
+		switch status := r.URL.Query().Get("status"); {
+		case !preview:
+			conds = append(conds, publishedClause)
+		case status != "":
+			args = append(args, status)
+			conds = append(conds, fmt.Sprintf("(%s) = $%d", statusExpr, len(args)))
+		}
+		where := strings.Join(append([]string{"($1 OR archived_at IS NULL)"}, conds...), " AND ")
+		var products []models.Product
+		err := h.DB.Select(&products,
+			`SELECT `+productColumns+` FROM product.products WHERE `+where+` ORDER BY created_at DESC`,
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		products, err = h.Currencies.Localize(products, currency)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.Translations.Translate(products, locales); err != nil {
+			return nil, err
+		}
+		if products == nil {
+			products = []models.Product{}
+		}
+		return products, nil
+	})
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch products"})
 		return
 	}
-	if products == nil {
-		products = []models.Product{}
-	}
-	writeJSON(w, http.StatusOK, products)
+	h.writeCached(w, r, body, hit)
 }
 
+// Get returns the current published product, including archived ones so that
+// existing orders and carts keep resolving; admins previewing see every
+// status. With ?version= or ?as_of= it returns the product as it was at that
+// revision instead.
 func (h *ProductHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	var product models.Product
-	err := h.DB.QueryRowx(
-		`SELECT id, name, description, price, stock, created_at, updated_at FROM product.products WHERE id = $1`, id,
-	).StructScan(&product)
-	if err == sql.ErrNoRows {
+	if r.URL.Query().Has("version") || r.URL.Query().Has("as_of") {
+		h.getRevision(w, r, id)
+		return
+	}
+	currency, ok := h.Currencies.requestCurrency(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be an ISO 4217 code"})
+		return
+	}
+	locales, ok := h.Translations.requestLocales(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag such as fr or fr-CA"})
+		return
+	}
+
+	body, hit, err := h.cached(r, "product", cacheKey("product:"+id+":", r, locales), func() (interface{}, error) {
+		product, err := loadProduct(h.DB, id)
+		if err != nil {
+			return nil, err
+		}
+		if product == nil || (product.Status != models.StatusPublished && !previewing(r)) {
+			return nil, errProductNotFound
+		}
+		localized, err := h.Currencies.Localize([]models.Product{*product}, currency)
+		if err != nil {
+			return nil, err
+		}
+		if len(localized) == 0 {
+			return nil, errNotPriced
+		}
+		if err := h.Translations.Translate(localized, locales); err != nil {
+			return nil, err
+		}
+		return localized[0], nil
+	})
+	switch {
+	case err == errProductNotFound:
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
 		return
+	case err == errNotPriced:
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "product is not priced in " + currency})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
 	}
-	if err != nil {
+
+	var product models.Product
+	if err := json.Unmarshal(body, &product); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
 		return
 	}
-	writeJSON(w, http.StatusOK, product)
+	tag := representationTag(product.ID, product.Version, body)
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.writeCached(w, r, body, hit)
 }
 
 func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -58,17 +189,97 @@ func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name and positive price are required"})
 		return
 	}
+	if req.ReorderThreshold < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reorder_threshold cannot be negative"})
+		return
+	}
+	if req.Slug != "" && !validSlug(req.Slug) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidSlug.Error()})
+		return
+	}
+	if err := validateImages(req.Images); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	fieldErrs, err := validateAttributes(h.DB, req.Type, req.Attributes)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to validate attributes"})
+		return
+	}
+	if len(fieldErrs) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid attributes", "fields": fieldErrs})
+		return
+	}
+	attributes := "{}"
+	if len(req.Attributes) > 0 && string(req.Attributes) != "null" {
+		attributes = string(req.Attributes)
+	}
+	status, publishAt, err := initialStatus(req.Status, req.PublishAt)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var requested *string
+	if req.Slug != "" {
+		requested = &req.Slug
+	}
+	slug, err := assignSlug(tx, "", requested, nil, req.Name)
+	if err == errSlugTaken {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to assign slug"})
+		return
+	}
+	if err := ensureCategory(tx, req.Category); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create category"})
+		return
+	}
 
 	var product models.Product
-	err := h.DB.QueryRowx(
-		`INSERT INTO product.products (name, description, price, stock) VALUES ($1, $2, $3, $4) 
-		 RETURNING id, name, description, price, stock, created_at, updated_at`,
-		req.Name, req.Description, req.Price, req.Stock,
+	err = tx.QueryRowx(
+		`INSERT INTO product.products (sku, name, slug, description, category, type_id, attributes, price, stock, reorder_threshold,
+			status, publish_at, published_at, images)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+			$11, $12, CASE WHEN $11 = '`+models.StatusPublished+`' THEN NOW() END, $13)
+		 RETURNING `+productColumns,
+		req.SKU, req.Name, slug, req.Description, req.Category, req.Type, attributes, req.Price, req.Stock, req.ReorderThreshold,
+		status, publishAt, pq.Array(append([]string{}, req.Images...)),
 	).StructScan(&product)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create product"})
 		return
 	}
+	if err := adjustDefaultStock(tx, product.ID, product.Stock, models.LedgerReceipt, actor(r), "product created"); err != nil {
+		writeMovementError(w, err)
+		return
+	}
+	if err := recordRevision(tx, product.ID, models.RevisionCreated, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	if err := recordPrice(tx, product.ID, models.PriceReasonCreated, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record price history"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	h.invalidate(product.ID)
+	product.Currency = h.Currencies.Base
+	publishCatalogEvent(h.Channel, models.EventProductCreated, nil, &product, actor(r))
+
+	w.Header().Set("ETag", etag(product.ID, product.Version))
 	writeJSON(w, http.StatusCreated, product)
 }
 
@@ -79,43 +290,244 @@ func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
 		return
 	}
+	if req.ReorderThreshold != nil && *req.ReorderThreshold < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "reorder_threshold cannot be negative"})
+		return
+	}
+	if req.Slug != nil && !validSlug(*req.Slug) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidSlug.Error()})
+		return
+	}
+	if err := validateImages(req.Images); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := h.checkIfMatch(w, r, tx, id)
+	if !ok {
+		return
+	}
+
+	if req.Stock != nil && before.IsBundle {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": errBundleStock.Error()})
+		return
+	}
+
+	typeID, attributes := before.Type, before.Attributes
+	if req.Type != nil {
+		typeID = req.Type
+		if *req.Type == "" {
+			typeID = nil
+		}
+	}
+	if req.Attributes != nil {
+		attributes = req.Attributes
+		if string(attributes) == "null" {
+			attributes = json.RawMessage("{}")
+		}
+	}
+	if req.Type != nil || req.Attributes != nil {
+		fieldErrs, err := validateAttributes(tx, typeID, attributes)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to validate attributes"})
+			return
+		}
+		if len(fieldErrs) > 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid attributes", "fields": fieldErrs})
+			return
+		}
+	}
+	name := before.Name
+	if req.Name != nil {
+		name = *req.Name
+	}
+	slug, err := assignSlug(tx, id, req.Slug, before.Slug, name)
+	if err == errSlugTaken {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to assign slug"})
+		return
+	}
+	if err := ensureCategory(tx, req.Category); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create category"})
+		return
+	}
 
 	var product models.Product
-	err := h.DB.QueryRowx(
+	err = tx.QueryRowx(
 		`UPDATE product.products SET 
 			name = COALESCE($1, name),
 			description = COALESCE($2, description),
 			price = COALESCE($3, price),
 			stock = COALESCE($4, stock),
+			sku = COALESCE($5, sku),
+			reorder_threshold = COALESCE($6, reorder_threshold),
+			category = COALESCE($7, category),
+			type_id = $8,
+			attributes = $9,
+			slug = $10,
+			images = COALESCE($11, images),
+			version = version + 1,
 			updated_at = NOW()
-		 WHERE id = $5
-		 RETURNING id, name, description, price, stock, created_at, updated_at`,
-		req.Name, req.Description, req.Price, req.Stock, id,
+		 WHERE id = $12 AND archived_at IS NULL
+		 RETURNING `+productColumns,
+		req.Name, req.Description, req.Price, req.Stock, req.SKU, req.ReorderThreshold, req.Category,
+		typeID, string(attributes), slug, pq.Array(req.Images), id,
 	).StructScan(&product)
 	if err == sql.ErrNoRows {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product is archived"})
 		return
 	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update product"})
 		return
 	}
+	if err := adjustDefaultStock(tx, product.ID, product.Stock-before.Stock, models.LedgerManualCount, actor(r), "product update"); err != nil {
+		writeMovementError(w, err)
+		return
+	}
+	if err := moveSlug(tx, models.SlugKindProduct, id, before.Slug, slug); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record slug redirect"})
+		return
+	}
+	if err := recordRevision(tx, product.ID, models.RevisionUpdated, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	if err := recordPrice(tx, product.ID, models.PriceReasonUpdated, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record price history"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	h.invalidate(product.ID)
+	product.Currency, before.Currency = h.Currencies.Base, h.Currencies.Base
+	publishCatalogEvent(h.Channel, models.EventProductUpdated, before, &product, actor(r))
+	if before.Stock != product.Stock {
+		publishCatalogEvent(h.Channel, models.EventProductStockChanged, before, &product, actor(r))
+	}
+
+	w.Header().Set("ETag", etag(product.ID, product.Version))
 	writeJSON(w, http.StatusOK, product)
 }
 
+// Delete archives the product rather than removing the row, since orders and
+// carts keep referencing it by ID.
 func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, true)
+}
+
+func (h *ProductHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	h.setArchived(w, r, false)
+}
+
+func (h *ProductHandler) setArchived(w http.ResponseWriter, r *http.Request, archive bool) {
 	id := chi.URLParam(r, "id")
-	result, err := h.DB.Exec(`DELETE FROM product.products WHERE id = $1`, id)
+	tx, err := h.DB.Beginx()
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete product"})
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
 		return
 	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+	defer tx.Rollback()
+
+	before, ok := h.checkIfMatch(w, r, tx, id)
+	if !ok {
+		return
+	}
+
+	action, archivedAt, precondition := models.RevisionArchived, "NOW()", "archived_at IS NULL"
+	conflict, failure := "product is already archived", "failed to archive product"
+	eventType := models.EventProductDeleted
+	if !archive {
+		action, archivedAt, precondition = models.RevisionRestored, "NULL", "archived_at IS NOT NULL"
+		conflict, failure = "product is not archived", "failed to restore product"
+		eventType = models.EventProductUpdated
+	}
+
+	var product models.Product
+	err = tx.QueryRowx(
+		`UPDATE product.products SET archived_at = `+archivedAt+`, version = version + 1, updated_at = NOW()
+		 WHERE id = $1 AND `+precondition+`
+		 RETURNING `+productColumns, id,
+	).StructScan(&product)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": conflict})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": failure})
+		return
+	}
+	if err := recordRevision(tx, id, action, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"message": "product deleted"})
+	h.invalidate(product.ID)
+	product.Currency, before.Currency = h.Currencies.Base, h.Currencies.Base
+	publishCatalogEvent(h.Channel, eventType, before, &product, actor(r))
+
+	w.Header().Set("ETag", etag(product.ID, product.Version))
+	writeJSON(w, http.StatusOK, product)
+}
+
+// checkIfMatch locks the product row for the rest of the transaction and
+// enforces the request's If-Match precondition against its current version.
+// It returns the locked product, or writes the error response and returns
+// false when the write must not proceed.
+func (h *ProductHandler) checkIfMatch(w http.ResponseWriter, r *http.Request, tx *sqlx.Tx, id string) (*models.Product, bool) {
+	product, ok := lockProduct(w, tx, id)
+	if !ok {
+		return nil, false
+	}
+
+	if im := r.Header.Get("If-Match"); im != "" && !versionMatches(im, id, product.Version) {
+		w.Header().Set("ETag", etag(id, product.Version))
+		writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "product has been modified"})
+		return nil, false
+	}
+	return product, true
+}
+
+// validateImages checks that images are absolute http(s) URLs.
+func validateImages(images []string) error {
+	if len(images) > maxImages {
+		return fmt.Errorf("at most %d images are allowed", maxImages)
+	}
+	for _, image := range images {
+		u, err := url.Parse(image)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("image %q is not an absolute http(s) URL", image)
+		}
+	}
+	return nil
+}
+
+// loadProduct reads a product snapshot, returning nil if it does not exist.
+func loadProduct(q sqlx.Queryer, id string) (*models.Product, error) {
+	var product models.Product
+	err := q.QueryRowx(`SELECT `+productColumns+` FROM product.products WHERE id = $1`, id).StructScan(&product)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
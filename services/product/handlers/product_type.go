@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const productTypeColumns = `id, name, schema, created_at, updated_at`
+
+var productTypeID = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+// attributeParam is the List query parameter prefix for attribute filters,
+// e.g. ?attr.author=Tolkien.
+const attributeParam = "attr."
+
+type ProductTypeHandler struct {
+	DB *sqlx.DB
+}
+
+func NewProductTypeHandler(db *sqlx.DB) *ProductTypeHandler {
+	return &ProductTypeHandler{DB: db}
+}
+
+func (h *ProductTypeHandler) List(w http.ResponseWriter, r *http.Request) {
+	var types []models.ProductType
+	if err := h.DB.Select(&types, `SELECT `+productTypeColumns+` FROM product.product_types ORDER BY id`); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product types"})
+		return
+	}
+	if types == nil {
+		types = []models.ProductType{}
+	}
+	writeJSON(w, http.StatusOK, types)
+}
+
+func (h *ProductTypeHandler) Get(w http.ResponseWriter, r *http.Request) {
+	var productType models.ProductType
+	err := h.DB.Get(&productType,
+		`SELECT `+productTypeColumns+` FROM product.product_types WHERE id = $1`, chi.URLParam(r, "typeID"))
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product type not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product type"})
+		return
+	}
+	writeJSON(w, http.StatusOK, productType)
+}
+
+func (h *ProductTypeHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateProductTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if !productTypeID.MatchString(req.ID) || req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "lowercase id and name are required"})
+		return
+	}
+	if _, err := compileSchema(req.Schema); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var productType models.ProductType
+	err := h.DB.QueryRowx(
+		`INSERT INTO product.product_types (id, name, schema) VALUES ($1, $2, $3) RETURNING `+productTypeColumns,
+		req.ID, req.Name, string(req.Schema),
+	).StructScan(&productType)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product type already exists"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create product type"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, productType)
+}
+
+// Update changes a type's name or schema. Existing products are not
+// revalidated; a product is checked against the new schema on its next write.
+func (h *ProductTypeHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateProductTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	var schema *string
+	if req.Schema != nil {
+		if _, err := compileSchema(req.Schema); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s := string(req.Schema)
+		schema = &s
+	}
+
+	var productType models.ProductType
+	err := h.DB.QueryRowx(
+		`UPDATE product.product_types SET name = COALESCE($1, name), schema = COALESCE($2, schema), updated_at = NOW()
+		 WHERE id = $3 RETURNING `+productTypeColumns,
+		req.Name, schema, chi.URLParam(r, "typeID"),
+	).StructScan(&productType)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product type not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update product type"})
+		return
+	}
+	writeJSON(w, http.StatusOK, productType)
+}
+
+func compileSchema(schema json.RawMessage) (*jsonschema.Schema, error) {
+	if len(schema) == 0 {
+		return nil, errors.New("schema is required")
+	}
+	compiler := jsonschema.NewCompiler()
+	// Schemas are self-contained; never let a $ref read files or URLs.
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external reference %s is not allowed", s)
+	}
+	if err := compiler.AddResource("product-type.json", bytes.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	compiled, err := compiler.Compile("product-type.json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return compiled, nil
+}
+
+// validateAttributes checks attributes against the schema of typeID and
+// returns the offending fields. Attributes are only allowed on typed
+// products.
+func validateAttributes(q sqlx.Queryer, typeID *string, attributes json.RawMessage) ([]models.FieldError, error) {
+	empty := len(attributes) == 0 || string(attributes) == "null" || string(bytes.TrimSpace(attributes)) == "{}"
+	if typeID == nil {
+		if !empty {
+			return []models.FieldError{{Field: "attributes", Message: "attributes require a product type"}}, nil
+		}
+		return nil, nil
+	}
+
+	var schema json.RawMessage
+	err := q.QueryRowx(`SELECT schema FROM product.product_types WHERE id = $1`, *typeID).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return []models.FieldError{{Field: "type", Message: "unknown product type"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var instance interface{} = map[string]interface{}{}
+	if len(attributes) > 0 {
+		if err := json.Unmarshal(attributes, &instance); err != nil {
+			return []models.FieldError{{Field: "attributes", Message: "attributes must be valid JSON"}}, nil
+		}
+	}
+	err = compiled.Validate(instance)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return fieldErrors(validationErr, nil), nil
+	}
+	return nil, err
+}
+
+// fieldErrors flattens a schema validation error into its leaf causes, which
+// name the actual offending fields.
+func fieldErrors(ve *jsonschema.ValidationError, errs []models.FieldError) []models.FieldError {
+	if len(ve.Causes) == 0 {
+		field := "attributes" + strings.ReplaceAll(ve.InstanceLocation, "/", ".")
+		return append(errs, models.FieldError{Field: field, Message: ve.Message})
+	}
+	for _, cause := range ve.Causes {
+		errs = fieldErrors(cause, errs)
+	}
+	return errs
+}
+
+// attributeFilters turns ?type= and ?attr.<name>=<value> parameters into
+// conditions on product.products, numbering placeholders after args. Values
+// match either as JSON (numbers, booleans) or as plain strings, and use
+// containment so the attributes GIN index applies.
+func attributeFilters(query url.Values, args []interface{}) ([]string, []interface{}) {
+	var conds []string
+	if t := query.Get("type"); t != "" {
+		args = append(args, t)
+		conds = append(conds, fmt.Sprintf("type_id = $%d", len(args)))
+	}
+	for param, values := range query {
+		name := strings.TrimPrefix(param, attributeParam)
+		if name == param || name == "" {
+			continue
+		}
+		for _, value := range values {
+			asString, _ := json.Marshal(map[string]string{name: value})
+			args = append(args, string(asString))
+			cond := fmt.Sprintf("attributes @> $%d", len(args))
+
+			var typed interface{}
+			if err := json.Unmarshal([]byte(value), &typed); err == nil {
+				if _, isString := typed.(string); !isString {
+					asJSON, _ := json.Marshal(map[string]interface{}{name: typed})
+					args = append(args, string(asJSON))
+					cond = fmt.Sprintf("(%s OR attributes @> $%d)", cond, len(args))
+				}
+			}
+			conds = append(conds, cond)
+		}
+	}
+	return conds, args
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// statusExpr is the product's stored status, except that a scheduled product
+// reads as published as soon as its publish_at passes, so visibility does not
+// wait for the publish scheduler to catch up.
+const statusExpr = `CASE WHEN products.status = '` + models.StatusScheduled + `' AND products.publish_at <= NOW()
+	THEN '` + models.StatusPublished + `' ELSE products.status END`
+
+// publishedClause restricts a query to products the public catalog shows.
+const publishedClause = `(` + statusExpr + `) = '` + models.StatusPublished + `'`
+
+var errInvalidStatus = errors.New("status must be draft or published, and publish_at requires no status or scheduled")
+
+// previewing reports whether the request asks to see products regardless of
+// status and comes from an admin. Previews bypass the cache.
+func previewing(r *http.Request) bool {
+	return r.URL.Query().Get("preview") == "true" && r.Header.Get("X-User-Role") == "admin"
+}
+
+// admin reports whether the caller's auth token carries the admin role,
+// answering 403 if not.
+func admin(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("X-User-Role") != "admin" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin role required"})
+		return false
+	}
+	return true
+}
+
+// initialStatus resolves the status and publish time a new product starts
+// with. Products start as drafts unless published or scheduled explicitly.
+func initialStatus(status string, publishAt *time.Time) (string, *time.Time, error) {
+	if publishAt != nil {
+		if status != "" && status != models.StatusScheduled {
+			return "", nil, errInvalidStatus
+		}
+		if publishAt.After(time.Now()) {
+			return models.StatusScheduled, publishAt, nil
+		}
+		return models.StatusPublished, nil, nil
+	}
+	switch status {
+	case "", models.StatusDraft:
+		return models.StatusDraft, nil, nil
+	case models.StatusPublished:
+		return models.StatusPublished, nil, nil
+	}
+	return "", nil, errInvalidStatus
+}
+
+// Publish makes the product visible now, or schedules it when publish_at is
+// in the future. Scheduling replaces any earlier schedule. Admins only.
+func (h *ProductHandler) Publish(w http.ResponseWriter, r *http.Request) {
+	if !admin(w, r) {
+		return
+	}
+	var req models.PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	status, publishAt, action := models.StatusPublished, (*time.Time)(nil), models.RevisionPublished
+	if req.PublishAt != nil && req.PublishAt.After(time.Now()) {
+		status, publishAt, action = models.StatusScheduled, req.PublishAt, models.RevisionScheduled
+	}
+	h.setStatus(w, r, status, publishAt, action)
+}
+
+// Unpublish takes the product out of the public catalog, cancelling any
+// pending schedule. Admins only.
+func (h *ProductHandler) Unpublish(w http.ResponseWriter, r *http.Request) {
+	if !admin(w, r) {
+		return
+	}
+	h.setStatus(w, r, models.StatusUnpublished, nil, models.RevisionUnpublished)
+}
+
+func (h *ProductHandler) setStatus(w http.ResponseWriter, r *http.Request, status string, publishAt *time.Time, action string) {
+	id := chi.URLParam(r, "id")
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := h.checkIfMatch(w, r, tx, id)
+	if !ok {
+		return
+	}
+	if before.ArchivedAt != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product is archived"})
+		return
+	}
+	switch {
+	case status == models.StatusUnpublished && before.Status != models.StatusPublished && before.Status != models.StatusScheduled:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product is not published or scheduled"})
+		return
+	case status != models.StatusUnpublished && before.Status == models.StatusPublished:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "product is already published"})
+		return
+	}
+
+	var product models.Product
+	err = tx.QueryRowx(
+		`UPDATE product.products SET status = $1, publish_at = $2,
+			published_at = CASE WHEN $1 = '`+models.StatusPublished+`' THEN NOW() ELSE published_at END,
+			version = version + 1, updated_at = NOW()
+		 WHERE id = $3
+		 RETURNING `+productColumns,
+		status, publishAt, id,
+	).StructScan(&product)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update product status"})
+		return
+	}
+	if err := recordRevision(tx, id, action, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	h.invalidate(product.ID)
+	product.Currency, before.Currency = h.Currencies.Base, h.Currencies.Base
+	eventType := models.EventProductUpdated
+	switch status {
+	case models.StatusPublished:
+		eventType = models.EventProductPublished
+	case models.StatusUnpublished:
+		eventType = models.EventProductUnpublished
+	}
+	publishCatalogEvent(h.Channel, eventType, before, &product, actor(r))
+
+	w.Header().Set("ETag", etag(product.ID, product.Version))
+	writeJSON(w, http.StatusOK, product)
+}
+
+// StartPublishScheduler moves scheduled products whose publish time has
+// passed to published, recording the revision and publishing
+// product.published for each.
+func (h *ProductHandler) StartPublishScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.publishDue()
+	}
+}
+
+// publishDue publishes due products in one transaction. SKIP LOCKED lets
+// every replica run the scheduler without publishing a product twice.
+func (h *ProductHandler) publishDue() {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		log.Printf("Failed to start publish transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var due []string
+	err = tx.Select(&due,
+		`SELECT id FROM product.products
+		 WHERE status = '`+models.StatusScheduled+`' AND publish_at <= NOW() AND archived_at IS NULL
+		 ORDER BY publish_at LIMIT 100 FOR UPDATE SKIP LOCKED`)
+	if err != nil {
+		log.Printf("Failed to fetch scheduled products: %v", err)
+		return
+	}
+
+	type change struct{ before, after *models.Product }
+	changes := make([]change, 0, len(due))
+	for _, id := range due {
+		before, err := loadProduct(tx, id)
+		if err == nil && before == nil {
+			err = sql.ErrNoRows
+		}
+		if err == nil {
+			// statusExpr already reports it as published.
+			before.Status = models.StatusScheduled
+			_, err = tx.Exec(
+				`UPDATE product.products SET status = '`+models.StatusPublished+`', published_at = publish_at,
+					version = version + 1, updated_at = NOW()
+				 WHERE id = $1`, id)
+		}
+		if err == nil {
+			err = recordRevision(tx, id, models.RevisionPublished, "scheduler")
+		}
+		var after *models.Product
+		if err == nil {
+			after, err = loadProduct(tx, id)
+		}
+		if err != nil {
+			log.Printf("Failed to publish scheduled product %s: %v", id, err)
+			return
+		}
+		before.Currency, after.Currency = h.Currencies.Base, h.Currencies.Base
+		changes = append(changes, change{before, after})
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit scheduled publishing: %v", err)
+		return
+	}
+	for _, c := range changes {
+		h.invalidate(c.after.ID)
+		publishCatalogEvent(h.Channel, models.EventProductPublished, c.before, c.after, "scheduler")
+	}
+}
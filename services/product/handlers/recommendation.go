@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// similarityWindow is how far back orders count towards co-purchases.
+	similarityWindow = "180 days"
+	// similarityTopN is how many related products are kept per product.
+	similarityTopN = 50
+	// similarityLockKey is the advisory lock that keeps replicas from
+	// rebuilding the similarity table at the same time.
+	similarityLockKey = 360036
+)
+
+// orderedLines is the set of products per order counted for co-purchases and
+// bestsellers. Failed and cancelled orders are left out.
+const orderedLines = `SELECT i.order_id, i.product_id, i.quantity FROM orders.order_items i
+	JOIN orders.orders o ON o.id = i.order_id
+	WHERE o.status NOT IN ('failed', 'cancelled') AND o.created_at > NOW() - '` + similarityWindow + `'::interval`
+
+type RecommendationHandler struct {
+	DB           *sqlx.DB
+	Currencies   *CurrencyHandler
+	Translations *TranslationHandler
+}
+
+func NewRecommendationHandler(db *sqlx.DB, currencies *CurrencyHandler, translations *TranslationHandler) *RecommendationHandler {
+	return &RecommendationHandler{DB: db, Currencies: currencies, Translations: translations}
+}
+
+// Related returns products frequently bought together with the product,
+// topped up with bestsellers from its category. Archived and out-of-stock
+// products are never recommended.
+func (h *RecommendationHandler) Related(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	limit := 10
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 50 {
+		limit = v
+	}
+	currency, ok := h.Currencies.requestCurrency(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "currency must be an ISO 4217 code"})
+		return
+	}
+	locales, ok := h.Translations.requestLocales(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag such as fr or fr-CA"})
+		return
+	}
+
+	product, err := loadProduct(h.DB, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	if product == nil || (product.Status != models.StatusPublished && !previewing(r)) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		return
+	}
+
+	var related []models.RelatedProduct
+	err = h.DB.Select(&related,
+		`SELECT `+productColumns+`, s.score, '`+models.RelatedBoughtTogether+`' AS source
+		 FROM product.product_similarities s
+		 JOIN product.products ON products.id = s.related_product_id
+		 WHERE s.product_id = $1 AND products.archived_at IS NULL AND `+publishedClause+` AND `+availableExpr+` > 0
+		 ORDER BY s.score DESC, s.co_occurrences DESC
+		 LIMIT $2`,
+		id, limit,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch recommendations"})
+		return
+	}
+
+	if len(related) < limit && product.Category != nil {
+		exclude := []string{id}
+		for _, p := range related {
+			exclude = append(exclude, p.ID)
+		}
+		var bestsellers []models.RelatedProduct
+		err = h.DB.Select(&bestsellers,
+			`SELECT `+productColumns+`, NULL::float8 AS score, '`+models.RelatedCategoryBestseller+`' AS source
+			 FROM product.products
+			 LEFT JOIN (
+				SELECT l.product_id, SUM(l.quantity) AS sold FROM (`+orderedLines+`) l GROUP BY l.product_id
+			 ) sales ON sales.product_id = products.id
+			 WHERE products.category = $1 AND NOT (products.id = ANY($2))
+				AND products.archived_at IS NULL AND `+publishedClause+` AND `+availableExpr+` > 0
+			 ORDER BY COALESCE(sales.sold, 0) DESC, products.created_at DESC
+			 LIMIT $3`,
+			*product.Category, pq.Array(exclude), limit-len(related),
+		)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch recommendations"})
+			return
+		}
+		related = append(related, bestsellers...)
+	}
+
+	related, err = h.localize(related, currency, locales)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to convert prices"})
+		return
+	}
+	writeJSON(w, http.StatusOK, related)
+}
+
+// localize prices recommendations in currency, dropping those that cannot be,
+// and translates them along locales.
+func (h *RecommendationHandler) localize(related []models.RelatedProduct, currency string, locales []string) ([]models.RelatedProduct, error) {
+	products := make([]models.Product, len(related))
+	for i, p := range related {
+		products[i] = p.Product
+	}
+	products, err := h.Currencies.Localize(products, currency)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Translations.Translate(products, locales); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+
+	localized := make([]models.RelatedProduct, 0, len(related))
+	for _, p := range related {
+		if product, ok := byID[p.ID]; ok {
+			p.Product = product
+			localized = append(localized, p)
+		}
+	}
+	return localized, nil
+}
+
+// StartSimilarityJob rebuilds the item-to-item similarity table now and then
+// on every interval.
+func (h *RecommendationHandler) StartSimilarityJob(interval time.Duration) {
+	h.rebuildSimilarities()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.rebuildSimilarities()
+	}
+}
+
+// rebuildSimilarities scores each pair of products bought in the same order
+// by cosine similarity, co-purchases / sqrt(orders of a * orders of b), and
+// keeps the top similarityTopN per product.
+func (h *RecommendationHandler) rebuildSimilarities() {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		log.Printf("Failed to start similarity transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.Get(&locked, `SELECT pg_try_advisory_xact_lock($1)`, similarityLockKey); err != nil || !locked {
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM product.product_similarities`); err != nil {
+		log.Printf("Failed to clear product similarities: %v", err)
+		return
+	}
+	result, err := tx.Exec(
+		`WITH lines AS (
+			SELECT DISTINCT l.order_id, l.product_id FROM (`+orderedLines+`) l
+		 ), counts AS (
+			SELECT product_id, COUNT(*) AS orders FROM lines GROUP BY product_id
+		 ), pairs AS (
+			SELECT a.product_id, b.product_id AS related_product_id, COUNT(*) AS co_occurrences
+			FROM lines a JOIN lines b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+			GROUP BY a.product_id, b.product_id
+		 ), scored AS (
+			SELECT p.*, p.co_occurrences / SQRT(ca.orders * cb.orders) AS score
+			FROM pairs p
+			JOIN counts ca ON ca.product_id = p.product_id
+			JOIN counts cb ON cb.product_id = p.related_product_id
+		 ), ranked AS (
+			SELECT s.*, ROW_NUMBER() OVER (
+				PARTITION BY s.product_id ORDER BY s.score DESC, s.co_occurrences DESC
+			) AS rank
+			FROM scored s
+		 )
+		 INSERT INTO product.product_similarities (product_id, related_product_id, co_occurrences, score)
+		 SELECT product_id, related_product_id, co_occurrences, score FROM ranked WHERE rank <= $1`,
+		similarityTopN,
+	)
+	if err != nil {
+		log.Printf("Failed to compute product similarities: %v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit product similarities: %v", err)
+		return
+	}
+	n, _ := result.RowsAffected()
+	log.Printf("Rebuilt product similarities: %d pairs", n)
+}
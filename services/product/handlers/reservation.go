@@ -0,0 +1,589 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const reservationColumns = `id, order_id, product_id, quantity, status, expires_at, created_at, updated_at`
+
+// activeReservationFilter matches reservations that still hold stock. Expired
+// rows stop counting immediately, even before the expiry worker marks them.
+const activeReservationFilter = `status = 'active' AND expires_at > NOW()`
+
+var errUnknownProduct = errors.New("unknown product")
+
+type ReservationHandler struct {
+	DB       *sqlx.DB
+	Channel  *amqp.Channel
+	TTL      time.Duration
+	Strategy FulfilmentStrategy
+}
+
+func NewReservationHandler(db *sqlx.DB, ch *amqp.Channel) *ReservationHandler {
+	ttl := 15 * time.Minute
+	if v := os.Getenv("RESERVATION_TTL"); v != "" {
+		if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+			ttl = parsed
+		}
+	}
+	return &ReservationHandler{DB: db, Channel: ch, TTL: ttl, Strategy: fulfilmentStrategyFromEnv()}
+}
+
+func (h *ReservationHandler) Reserve(w http.ResponseWriter, r *http.Request) {
+	var req models.ReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.OrderID == "" || len(req.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "order_id and at least one item are required"})
+		return
+	}
+	for _, item := range req.Items {
+		if item.ProductID == "" || item.Quantity <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "each item needs a product_id and positive quantity"})
+			return
+		}
+	}
+
+	ttl := h.TTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+
+	reservations, err := h.reserve(req.OrderID, req.Items, ttl)
+	var stockErr *models.InsufficientStockError
+	switch {
+	case errors.As(err, &stockErr):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":      "insufficient stock",
+			"product_id": stockErr.ProductID,
+			"requested":  stockErr.Requested,
+			"available":  stockErr.Available,
+		})
+		return
+	case errors.Is(err, errUnknownProduct):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reserve stock"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, reservations)
+}
+
+func (h *ReservationHandler) Get(w http.ResponseWriter, r *http.Request) {
+	orderID := chi.URLParam(r, "orderID")
+	var reservations []models.Reservation
+	err := h.DB.Select(&reservations,
+		`SELECT `+reservationColumns+` FROM product.reservations WHERE order_id = $1 ORDER BY product_id`, orderID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch reservations"})
+		return
+	}
+	if len(reservations) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no reservations for order"})
+		return
+	}
+	writeJSON(w, http.StatusOK, reservations)
+}
+
+func (h *ReservationHandler) Commit(w http.ResponseWriter, r *http.Request) {
+	orderID := chi.URLParam(r, "orderID")
+	n, err := h.commit(orderID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit reservations"})
+		return
+	}
+	if n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active reservations for order"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "reservations committed"})
+}
+
+func (h *ReservationHandler) Release(w http.ResponseWriter, r *http.Request) {
+	orderID := chi.URLParam(r, "orderID")
+	n, err := h.release(orderID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to release reservations"})
+		return
+	}
+	if n == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active reservations for order"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "reservations released"})
+}
+
+// reserve holds stock for every item of an order, or for none of them. The
+// product rows are locked in a stable order so concurrent reservations for
+// overlapping products serialize instead of deadlocking.
+func (h *ReservationHandler) reserve(orderID string, items []models.ReservationItem, ttl time.Duration) ([]models.Reservation, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize with settle, so a payment outcome is either seen here as
+	// pending or finds the reservations this creates.
+	if err := lockOrder(tx, orderID); err != nil {
+		return nil, err
+	}
+
+	var existing []models.Reservation
+	if err := tx.Select(&existing,
+		`SELECT `+reservationColumns+` FROM product.reservations WHERE order_id = $1 ORDER BY product_id`, orderID,
+	); err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return existing, nil
+	}
+
+	// Bundles hold no stock; reserve and later decrement their components.
+	items, err = expandBundles(tx, items)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]int{}
+	for _, item := range items {
+		wanted[item.ProductID] += item.Quantity
+	}
+	ids := make([]string, 0, len(wanted))
+	for id := range wanted {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rows, err := tx.Query(
+		`SELECT id, stock FROM product.products WHERE id = ANY($1) AND archived_at IS NULL ORDER BY id FOR UPDATE`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	stock := map[string]int{}
+	for rows.Next() {
+		var id string
+		var s int
+		if err := rows.Scan(&id, &s); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stock[id] = s
+	}
+	rows.Close()
+	for _, id := range ids {
+		if _, ok := stock[id]; !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknownProduct, id)
+		}
+	}
+
+	rows, err = tx.Query(
+		`SELECT product_id, SUM(quantity) FROM product.reservations
+		 WHERE product_id = ANY($1) AND `+activeReservationFilter+` GROUP BY product_id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	reserved := map[string]int{}
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reserved[id] = n
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if available := stock[id] - reserved[id]; wanted[id] > available {
+			return nil, &models.InsufficientStockError{ProductID: id, Requested: wanted[id], Available: available}
+		}
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	reservations := make([]models.Reservation, 0, len(ids))
+	for _, id := range ids {
+		var res models.Reservation
+		err := tx.QueryRowx(
+			`INSERT INTO product.reservations (order_id, product_id, quantity, expires_at) VALUES ($1, $2, $3, $4)
+			 RETURNING `+reservationColumns,
+			orderID, id, wanted[id], expiresAt,
+		).StructScan(&res)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, res)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// lockOrder takes a transaction-scoped lock on an order's reservations.
+func lockOrder(tx *sqlx.Tx, orderID string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, orderID)
+	return err
+}
+
+// settle applies a payment outcome to an order's reservations: completed
+// payments commit them and failed ones release them. Payment can finish
+// before the order is reserved, since both react to order.created; the
+// outcome is then kept as a pending settlement, and deferred is true, until
+// settlePending applies it once the reservations exist.
+func (h *ReservationHandler) settle(orderID, paymentStatus string) (n int64, deferred bool, err error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+	if err := lockOrder(tx, orderID); err != nil {
+		return 0, false, err
+	}
+	var reserved bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM product.reservations WHERE order_id = $1)`, orderID).Scan(&reserved)
+	if err != nil {
+		return 0, false, err
+	}
+	if !reserved {
+		_, err = tx.Exec(
+			`INSERT INTO product.pending_settlements (order_id, payment_status) VALUES ($1, $2)
+			 ON CONFLICT (order_id) DO UPDATE SET payment_status = EXCLUDED.payment_status, created_at = NOW()`,
+			orderID, paymentStatus)
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, true, tx.Commit()
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	if paymentStatus == "completed" {
+		n, err = h.commit(orderID)
+	} else {
+		n, err = h.release(orderID)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = h.DB.Exec(`DELETE FROM product.pending_settlements WHERE order_id = $1`, orderID)
+	return n, false, err
+}
+
+// settlePending applies a payment outcome that arrived before the order was
+// reserved.
+func (h *ReservationHandler) settlePending(orderID string) error {
+	var paymentStatus string
+	err := h.DB.QueryRow(`SELECT payment_status FROM product.pending_settlements WHERE order_id = $1`, orderID).Scan(&paymentStatus)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n, _, err := h.settle(orderID, paymentStatus)
+	if err == nil {
+		log.Printf("Settled %d reservations for order %s (pending payment %s)", n, orderID, paymentStatus)
+	}
+	return err
+}
+
+// commit turns an order's active reservations into real stock decrements and
+// announces the stock change for each product. Reservations that have expired
+// but not yet been swept are not committed: their stock may already be held
+// by another order.
+func (h *ReservationHandler) commit(orderID string) (int64, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var productIDs []string
+	err = tx.Select(&productIDs,
+		`SELECT id FROM product.products
+		 WHERE id IN (SELECT product_id FROM product.reservations WHERE order_id = $1 AND `+activeReservationFilter+`)
+		 ORDER BY id FOR UPDATE`, orderID)
+	if err != nil {
+		return 0, err
+	}
+	before := make([]*models.Product, len(productIDs))
+	for i, id := range productIDs {
+		if before[i], err = loadProduct(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := h.allocateWarehouses(tx, orderID, productIDs); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE product.products p SET stock = p.stock - r.quantity, version = p.version + 1, updated_at = NOW()
+		 FROM (SELECT product_id, quantity FROM product.reservations WHERE order_id = $1 AND `+activeReservationFilter+`) r
+		 WHERE r.product_id = p.id`, orderID)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range productIDs {
+		if err := recordRevision(tx, id, models.RevisionStock, "order:"+orderID); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec(
+		`UPDATE product.reservations SET status = $1, updated_at = NOW() WHERE order_id = $2 AND `+activeReservationFilter,
+		models.ReservationCommitted, orderID)
+	if err != nil {
+		return 0, err
+	}
+	after := make([]*models.Product, len(productIDs))
+	for i, id := range productIDs {
+		if after[i], err = loadProduct(tx, id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for i := range productIDs {
+		publishCatalogEvent(h.Channel, models.EventProductStockChanged, before[i], after[i], "order:"+orderID)
+	}
+	n, _ := result.RowsAffected()
+	return n, nil
+}
+
+// allocateWarehouses picks the warehouses that ship an order's reserved
+// lines and takes the stock from them. The products must already be locked.
+func (h *ReservationHandler) allocateWarehouses(tx *sqlx.Tx, orderID string, productIDs []string) error {
+	var lines []models.ReservationItem
+	err := tx.Select(&lines,
+		`SELECT product_id, quantity FROM product.reservations WHERE order_id = $1 AND `+activeReservationFilter, orderID)
+	if err != nil {
+		return err
+	}
+	candidates, err := loadCandidates(tx, productIDs, nil)
+	if err != nil {
+		return err
+	}
+	plan, err := allocate(lines, candidates, h.Strategy)
+	if err != nil {
+		return err
+	}
+	for _, a := range plan.Allocations {
+		_, err := recordMovement(tx, models.LedgerEntry{
+			ProductID: a.ProductID, WarehouseID: a.WarehouseID, Delta: -a.Quantity,
+			Reason: models.LedgerSale, Actor: "order:" + orderID, Reference: orderID,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO product.fulfilment_allocations (order_id, product_id, warehouse_id, quantity, strategy)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			orderID, a.ProductID, a.WarehouseID, a.Quantity, plan.Strategy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *ReservationHandler) release(orderID string) (int64, error) {
+	result, err := h.DB.Exec(
+		`UPDATE product.reservations SET status = $1, updated_at = NOW() WHERE order_id = $2 AND status = 'active'`,
+		models.ReservationReleased, orderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartOrderConsumer reserves stock for every newly created order. Orders
+// that cannot be reserved, for lack of stock or an unknown product, are
+// reported with reservation.failed. Other failures are retried by requeueing
+// the message.
+func (h *ReservationHandler) StartOrderConsumer() {
+	q, err := h.Channel.QueueDeclare("product.reservations", true, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare queue: %v", err)
+		return
+	}
+
+	err = h.Channel.QueueBind(q.Name, "order.created", "orders", false, nil)
+	if err != nil {
+		log.Printf("Failed to bind queue: %v", err)
+		return
+	}
+
+	msgs, err := h.Channel.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to start consuming: %v", err)
+		return
+	}
+
+	log.Println("Started order consumer for stock reservations")
+	for msg := range msgs {
+		var event models.OrderEvent
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			log.Printf("Failed to unmarshal order event: %v", err)
+			msg.Ack(false)
+			continue
+		}
+		if len(event.Items) == 0 {
+			log.Printf("Order %s has no items to reserve", event.OrderID)
+			msg.Ack(false)
+			continue
+		}
+
+		_, err := h.reserve(event.OrderID, event.Items, h.TTL)
+		var stockErr *models.InsufficientStockError
+		switch {
+		case err == nil:
+			log.Printf("Reserved stock for order %s", event.OrderID)
+			if err := h.settlePending(event.OrderID); err != nil {
+				log.Printf("Failed to settle reservations for order %s, retrying: %v", event.OrderID, err)
+				time.Sleep(reservationRetryDelay)
+				msg.Nack(false, true)
+				continue
+			}
+		case errors.As(err, &stockErr), errors.Is(err, errUnknownProduct):
+			log.Printf("Cannot reserve stock for order %s: %v", event.OrderID, err)
+			h.publishReservationFailed(event.OrderID, err)
+		default:
+			log.Printf("Failed to reserve stock for order %s, retrying: %v", event.OrderID, err)
+			time.Sleep(reservationRetryDelay)
+			msg.Nack(false, true)
+			continue
+		}
+		msg.Ack(false)
+	}
+}
+
+// reservationRetryDelay paces redelivery of orders whose reservation failed
+// for a transient reason such as the database being unavailable.
+const reservationRetryDelay = 5 * time.Second
+
+// publishReservationFailed tells the order service an order could not be
+// reserved and will not be fulfilled.
+func (h *ReservationHandler) publishReservationFailed(orderID string, err error) {
+	event := models.ReservationFailedEvent{OrderID: orderID, Reason: err.Error(), OccurredAt: time.Now()}
+	var stockErr *models.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		event.ProductID = stockErr.ProductID
+		event.Requested = stockErr.Requested
+		event.Available = stockErr.Available
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal %s event: %v", models.EventReservationFailed, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = h.Channel.PublishWithContext(ctx,
+		"orders",
+		models.EventReservationFailed,
+		false, false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to publish %s event: %v", models.EventReservationFailed, err)
+	}
+}
+
+// StartPaymentStatusConsumer commits reservations for paid orders and
+// releases them for failed payments. Failures are retried by requeueing the
+// message, so a paid order's stock is never left to expire.
+func (h *ReservationHandler) StartPaymentStatusConsumer() {
+	q, err := h.Channel.QueueDeclare("product.payment.status", true, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare queue: %v", err)
+		return
+	}
+
+	err = h.Channel.QueueBind(q.Name, "payment.status", "payments", false, nil)
+	if err != nil {
+		log.Printf("Failed to bind queue: %v", err)
+		return
+	}
+
+	msgs, err := h.Channel.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to start consuming: %v", err)
+		return
+	}
+
+	log.Println("Started payment status consumer for stock reservations")
+	for msg := range msgs {
+		var event models.PaymentStatusEvent
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			log.Printf("Failed to unmarshal payment event: %v", err)
+			msg.Ack(false)
+			continue
+		}
+		if event.Status != "completed" && event.Status != "failed" {
+			msg.Ack(false)
+			continue
+		}
+
+		n, deferred, err := h.settle(event.OrderID, event.Status)
+		switch {
+		case err != nil:
+			log.Printf("Failed to settle reservations for order %s, retrying: %v", event.OrderID, err)
+			time.Sleep(reservationRetryDelay)
+			msg.Nack(false, true)
+			continue
+		case deferred:
+			log.Printf("Order %s is not reserved yet; payment %s will settle it once it is", event.OrderID, event.Status)
+		default:
+			log.Printf("Settled %d reservations for order %s (payment %s)", n, event.OrderID, event.Status)
+		}
+		msg.Ack(false)
+	}
+}
+
+// StartExpiryWorker periodically marks reservations past their TTL as expired
+func (h *ReservationHandler) StartExpiryWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		result, err := h.DB.Exec(
+			`UPDATE product.reservations SET status = $1, updated_at = NOW()
+			 WHERE status = 'active' AND expires_at <= NOW()`, models.ReservationExpired)
+		if err != nil {
+			log.Printf("Failed to expire reservations: %v", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Expired %d stock reservations", n)
+		}
+		// Orders that were never reserved leave their payment outcome behind
+		h.DB.Exec(`DELETE FROM product.pending_settlements WHERE created_at < NOW() - INTERVAL '1 day'`)
+	}
+}
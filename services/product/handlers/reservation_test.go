@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReserveValidation(t *testing.T) {
+	h := NewReservationHandler(nil, nil)
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"not json", `{`, "invalid request body"},
+		{"no order", `{"items":[{"product_id":"a","quantity":1}]}`, "order_id and at least one item are required"},
+		{"no items", `{"order_id":"o"}`, "order_id and at least one item are required"},
+		{"no product", `{"order_id":"o","items":[{"quantity":1}]}`, "each item needs a product_id and positive quantity"},
+		{"zero quantity", `{"order_id":"o","items":[{"product_id":"a","quantity":0}]}`, "each item needs a product_id and positive quantity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.Reserve(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want 400 %q", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReservationTTL(t *testing.T) {
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", 15 * time.Minute},
+		{"5m", 5 * time.Minute},
+		{"-1m", 15 * time.Minute},
+		{"soon", 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Setenv("RESERVATION_TTL", tt.env)
+		if got := NewReservationHandler(nil, nil).TTL; got != tt.want {
+			t.Errorf("RESERVATION_TTL=%q: TTL = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
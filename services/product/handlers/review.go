@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const reviewColumns = `id, product_id, user_id, order_id, rating, title, body, status, helpful_count, created_at, updated_at`
+
+// Aggregates only count approved reviews.
+const (
+	ratingAverageExpr = `(SELECT ROUND(AVG(rv.rating)::numeric, 2) FROM product.reviews rv
+		WHERE rv.product_id = products.id AND rv.status = 'approved')`
+	ratingCountExpr = `(SELECT COUNT(*) FROM product.reviews rv
+		WHERE rv.product_id = products.id AND rv.status = 'approved')`
+)
+
+type ReviewHandler struct {
+	DB *sqlx.DB
+}
+
+func NewReviewHandler(db *sqlx.DB) *ReviewHandler {
+	return &ReviewHandler{DB: db}
+}
+
+// Create accepts a review from a customer with a completed order containing
+// the product. New reviews wait in the moderation queue. The customer is
+// the one the caller's auth token names, so purchases cannot be borrowed.
+func (h *ReviewHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	productID := chi.URLParam(r, "id")
+
+	var req models.CreateReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.Rating < 1 || req.Rating > 5 || req.Body == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "rating between 1 and 5 and body are required"})
+		return
+	}
+
+	var orderID string
+	err := h.DB.QueryRow(
+		`SELECT o.id FROM orders.orders o
+		 JOIN orders.order_items i ON i.order_id = o.id
+		 WHERE o.user_id = $1 AND i.product_id = $2 AND o.status = 'completed'
+		 ORDER BY o.created_at DESC LIMIT 1`,
+		userID, productID,
+	).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "only customers who bought this product can review it"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify purchase"})
+		return
+	}
+
+	var review models.Review
+	err = h.DB.QueryRowx(
+		`INSERT INTO product.reviews (product_id, user_id, order_id, rating, title, body) VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING `+reviewColumns,
+		productID, userID, orderID, req.Rating, req.Title, req.Body,
+	).StructScan(&review)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "you have already reviewed this product"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create review"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, review)
+}
+
+// signedInUser returns the user the caller's auth token names, answering 401
+// for anonymous callers.
+func signedInUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+		return "", false
+	}
+	return userID, true
+}
+
+// List returns a product's approved reviews, newest first or with
+// ?sort=helpful most helpful first. The total is sent in X-Total-Count.
+func (h *ReviewHandler) List(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	order := "created_at DESC"
+	if r.URL.Query().Get("sort") == "helpful" {
+		order = "helpful_count DESC, created_at DESC"
+	}
+	h.list(w, r, `product_id = $1 AND status = 'approved'`, productID, order)
+}
+
+// Queue lists reviews by moderation status, pending by default. Admins only.
+func (h *ReviewHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	if !admin(w, r) {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReviewPending
+	}
+	h.list(w, r, `status = $1`, status, "created_at ASC")
+}
+
+func (h *ReviewHandler) list(w http.ResponseWriter, r *http.Request, where, arg, order string) {
+	limit, offset := 20, 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 100 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v >= 0 {
+		offset = v
+	}
+
+	var total int
+	if err := h.DB.Get(&total, `SELECT COUNT(*) FROM product.reviews WHERE `+where, arg); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch reviews"})
+		return
+	}
+
+	var reviews []models.Review
+	err := h.DB.Select(&reviews,
+		`SELECT `+reviewColumns+` FROM product.reviews WHERE `+where+` ORDER BY `+order+` LIMIT $2 OFFSET $3`,
+		arg, limit, offset,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch reviews"})
+		return
+	}
+	if reviews == nil {
+		reviews = []models.Review{}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, reviews)
+}
+
+// Moderate approves or rejects a review. Admins only.
+func (h *ReviewHandler) Moderate(w http.ResponseWriter, r *http.Request) {
+	if !admin(w, r) {
+		return
+	}
+	reviewID := chi.URLParam(r, "reviewID")
+	var req models.ModerateReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	switch req.Status {
+	case models.ReviewPending, models.ReviewApproved, models.ReviewRejected:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be pending, approved or rejected"})
+		return
+	}
+
+	var review models.Review
+	err := h.DB.QueryRowx(
+		`UPDATE product.reviews SET status = $1, updated_at = NOW() WHERE id = $2 RETURNING `+reviewColumns,
+		req.Status, reviewID,
+	).StructScan(&review)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "review not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to moderate review"})
+		return
+	}
+	writeJSON(w, http.StatusOK, review)
+}
+
+// Vote marks an approved review as helpful for the caller. Voting twice is a
+// no-op.
+func (h *ReviewHandler) Vote(w http.ResponseWriter, r *http.Request) {
+	h.setVote(w, r, true)
+}
+
+func (h *ReviewHandler) Unvote(w http.ResponseWriter, r *http.Request) {
+	h.setVote(w, r, false)
+}
+
+func (h *ReviewHandler) setVote(w http.ResponseWriter, r *http.Request, helpful bool) {
+	userID, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	reviewID := chi.URLParam(r, "reviewID")
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var author string
+	err = tx.QueryRow(
+		`SELECT user_id FROM product.reviews WHERE id = $1 AND status = 'approved' FOR UPDATE`, reviewID,
+	).Scan(&author)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "review not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch review"})
+		return
+	}
+	if author == userID {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "cannot vote on your own review"})
+		return
+	}
+
+	var result sql.Result
+	delta := 1
+	if helpful {
+		result, err = tx.Exec(
+			`INSERT INTO product.review_votes (review_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			reviewID, userID)
+	} else {
+		delta = -1
+		result, err = tx.Exec(`DELETE FROM product.review_votes WHERE review_id = $1 AND user_id = $2`, reviewID, userID)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record vote"})
+		return
+	}
+
+	var review models.Review
+	if n, _ := result.RowsAffected(); n > 0 {
+		err = tx.QueryRowx(
+			`UPDATE product.reviews SET helpful_count = helpful_count + $1 WHERE id = $2 RETURNING `+reviewColumns,
+			delta, reviewID,
+		).StructScan(&review)
+	} else {
+		err = tx.QueryRowx(`SELECT `+reviewColumns+` FROM product.reviews WHERE id = $1`, reviewID).StructScan(&review)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record vote"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	writeJSON(w, http.StatusOK, review)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The checks below answer before the database is touched, so the handler
+// needs none.
+func TestReviewAccess(t *testing.T) {
+	h := NewReviewHandler(nil)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		userID  string
+		role    string
+		status  int
+	}{
+		{"queue needs a user", h.Queue, http.MethodGet, "", "", http.StatusForbidden},
+		{"queue needs an admin", h.Queue, http.MethodGet, "user-1", "customer", http.StatusForbidden},
+		{"moderate needs an admin", h.Moderate, http.MethodPut, "user-1", "customer", http.StatusForbidden},
+		{"create needs a user", h.Create, http.MethodPost, "", "", http.StatusUnauthorized},
+		{"vote needs a user", h.Vote, http.MethodPost, "", "", http.StatusUnauthorized},
+		{"unvote needs a user", h.Unvote, http.MethodDelete, "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(`{"status":"approved","rating":5,"body":"ok"}`))
+			if tt.userID != "" {
+				req.Header.Set("X-User-ID", tt.userID)
+			}
+			if tt.role != "" {
+				req.Header.Set("X-User-Role", tt.role)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
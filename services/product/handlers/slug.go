@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+// publicPath is where the ingress exposes this service. Slug redirects point
+// clients back through it.
+const publicPath = "/api/products"
+
+// maxGeneratedSlug leaves room in the 255-character column for a collision
+// suffix.
+const maxGeneratedSlug = 200
+
+// sitemapLimit is the most URLs the sitemap protocol allows in one file.
+const sitemapLimit = 50000
+
+var (
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	errInvalidSlug = errors.New("slug must be lowercase letters and digits separated by single hyphens, at most 255 characters")
+	errSlugTaken   = errors.New("slug is already in use")
+)
+
+// slugFolds spells common accented Latin letters in ASCII; anything else
+// outside [a-z0-9] separates words.
+var slugFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y", 'ß': "ss",
+}
+
+// SlugHandler resolves products and categories by slug, keeps the redirect
+// history of changed slugs and serves the sitemap. Product slugs are set
+// through the product endpoints; see Create and Update.
+type SlugHandler struct {
+	DB       *sqlx.DB
+	Products *ProductHandler
+	SiteURL  string
+}
+
+func NewSlugHandler(db *sqlx.DB, products *ProductHandler) *SlugHandler {
+	return &SlugHandler{DB: db, Products: products, SiteURL: siteURL()}
+}
+
+// siteURL is the storefront's base URL from SITE_URL; product pages live at
+// /products/{slug} under it.
+func siteURL() string {
+	if v := strings.TrimSuffix(os.Getenv("SITE_URL"), "/"); v != "" {
+		return v
+	}
+	return "http://localhost"
+}
+
+// ProductBySlug serves the product like Get. An old slug redirects to the
+// product's current one.
+func (h *SlugHandler) ProductBySlug(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	var id string
+	err := h.DB.Get(&id, `SELECT id FROM product.products WHERE slug = $1`, slug)
+	if err == sql.ErrNoRows {
+		var current string
+		err = h.DB.Get(&current,
+			`SELECT p.slug FROM product.slug_redirects sr
+			 JOIN product.products p ON p.id::text = sr.target
+			 WHERE sr.kind = $1 AND sr.slug = $2 AND p.slug IS NOT NULL`,
+			models.SlugKindProduct, slug)
+		if err == nil {
+			redirect(w, r, publicPath+"/by-slug/"+current)
+			return
+		}
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+			return
+		}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	chi.RouteContext(r.Context()).URLParams.Add("id", id)
+	h.Products.Get(w, r)
+}
+
+// ListCategories lists categories with how many published products each has.
+func (h *SlugHandler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	var categories []models.Category
+	err := h.DB.Select(&categories,
+		`SELECT c.name, c.slug, c.created_at, c.updated_at, COUNT(products.id) AS product_count
+		 FROM product.categories c
+		 LEFT JOIN product.products ON products.category = c.name
+			AND products.archived_at IS NULL AND `+publishedClause+`
+		 GROUP BY c.name ORDER BY c.name`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch categories"})
+		return
+	}
+	if categories == nil {
+		categories = []models.Category{}
+	}
+	writeJSON(w, http.StatusOK, categories)
+}
+
+// GetCategory returns the category with the slug. An old slug redirects to
+// the category's current one.
+func (h *SlugHandler) GetCategory(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	category, err := h.loadCategory(h.DB, slug, false)
+	if err == sql.ErrNoRows {
+		var current string
+		err = h.DB.Get(&current,
+			`SELECT c.slug FROM product.slug_redirects sr
+			 JOIN product.categories c ON c.name = sr.target
+			 WHERE sr.kind = $1 AND sr.slug = $2`,
+			models.SlugKindCategory, slug)
+		if err == nil {
+			redirect(w, r, publicPath+"/categories/"+current)
+			return
+		}
+		if err == sql.ErrNoRows {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "category not found"})
+			return
+		}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch category"})
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+// SetCategorySlug changes a category's slug, keeping the old one as a
+// redirect.
+func (h *SlugHandler) SetCategorySlug(w http.ResponseWriter, r *http.Request) {
+	var req models.SetSlugRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if !validSlug(req.Slug) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": errInvalidSlug.Error()})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	category, err := h.loadCategory(tx, chi.URLParam(r, "slug"), true)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "category not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch category"})
+		return
+	}
+	if req.Slug != category.Slug {
+		taken, err := slugTaken(tx, models.SlugKindCategory, req.Slug, category.Name)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check slug"})
+			return
+		}
+		if taken {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": errSlugTaken.Error()})
+			return
+		}
+		if _, err := tx.Exec(`UPDATE product.categories SET slug = $1, updated_at = NOW() WHERE name = $2`, req.Slug, category.Name); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update category"})
+			return
+		}
+		if err := moveSlug(tx, models.SlugKindCategory, category.Name, &category.Slug, req.Slug); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record slug redirect"})
+			return
+		}
+	}
+	updated, err := h.loadCategory(tx, req.Slug, false)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch category"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *SlugHandler) loadCategory(q sqlx.Queryer, slug string, lock bool) (*models.Category, error) {
+	query := `SELECT c.name, c.slug, c.created_at, c.updated_at,
+			(SELECT COUNT(*) FROM product.products
+			 WHERE products.category = c.name AND products.archived_at IS NULL AND ` + publishedClause + `) AS product_count
+		 FROM product.categories c WHERE c.slug = $1`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	var category models.Category
+	if err := sqlx.Get(q, &category, query, slug); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// Sitemap lists the storefront URLs of published products and of the
+// categories that have any, under SITE_URL.
+func (h *SlugHandler) Sitemap(w http.ResponseWriter, r *http.Request) {
+	var entries []struct {
+		Path      string    `db:"path"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	err := h.DB.Select(&entries,
+		`SELECT '/products/' || slug AS path, updated_at FROM product.products
+		 WHERE slug IS NOT NULL AND archived_at IS NULL AND `+publishedClause+`
+		 UNION ALL
+		 SELECT '/categories/' || c.slug, MAX(products.updated_at) FROM product.categories c
+		 JOIN product.products ON products.category = c.name
+		 WHERE products.archived_at IS NULL AND `+publishedClause+`
+		 GROUP BY c.slug
+		 ORDER BY updated_at DESC LIMIT $1`, sitemapLimit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to build sitemap"})
+		return
+	}
+
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: make([]sitemapURL, len(entries))}
+	for i, e := range entries {
+		set.URLs[i] = sitemapURL{Loc: h.SiteURL + e.Path, LastMod: e.UpdatedAt.UTC().Format("2006-01-02")}
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		log.Printf("Failed to write sitemap: %v", err)
+	}
+}
+
+// Backfill gives slugs to products and categories that predate them.
+func (h *SlugHandler) Backfill() {
+	var products []struct {
+		ID   string `db:"id"`
+		Name string `db:"name"`
+	}
+	if err := h.DB.Select(&products, `SELECT id, name FROM product.products WHERE slug IS NULL ORDER BY created_at`); err != nil {
+		log.Printf("Failed to fetch products without slugs: %v", err)
+		return
+	}
+	for _, p := range products {
+		slug, err := uniqueSlug(h.DB, models.SlugKindProduct, p.ID, p.Name)
+		if err == nil {
+			_, err = h.DB.Exec(`UPDATE product.products SET slug = $1 WHERE id = $2 AND slug IS NULL`, slug, p.ID)
+		}
+		if err != nil {
+			log.Printf("Failed to assign slug to product %s: %v", p.ID, err)
+		}
+	}
+
+	var categories []string
+	err := h.DB.Select(&categories,
+		`SELECT DISTINCT category FROM product.products p
+		 WHERE category IS NOT NULL AND NOT EXISTS (SELECT 1 FROM product.categories c WHERE c.name = p.category)`)
+	if err != nil {
+		log.Printf("Failed to fetch categories without slugs: %v", err)
+		return
+	}
+	for i := range categories {
+		if err := ensureCategory(h.DB, &categories[i]); err != nil {
+			log.Printf("Failed to assign slug to category %q: %v", categories[i], err)
+		}
+	}
+	if len(products) > 0 || len(categories) > 0 {
+		log.Printf("Assigned slugs to %d products and %d categories", len(products), len(categories))
+	}
+}
+
+// redirect answers with a permanent redirect to path, keeping the query.
+func redirect(w http.ResponseWriter, r *http.Request, path string) {
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, path, http.StatusMovedPermanently)
+}
+
+func validSlug(slug string) bool {
+	return len(slug) <= 255 && slugPattern.MatchString(slug)
+}
+
+// slugify turns a name into a slug: lowercase ASCII words joined by hyphens.
+func slugify(name string) string {
+	var b strings.Builder
+	pendingHyphen := false
+	for _, c := range strings.ToLower(name) {
+		word := ""
+		switch {
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+			word = string(c)
+		default:
+			word = slugFolds[c]
+		}
+		if word == "" {
+			pendingHyphen = b.Len() > 0
+			continue
+		}
+		if pendingHyphen {
+			b.WriteByte('-')
+			pendingHyphen = false
+		}
+		b.WriteString(word)
+	}
+	slug := b.String()
+	if len(slug) > maxGeneratedSlug {
+		slug = strings.TrimRight(slug[:maxGeneratedSlug], "-")
+	}
+	return slug
+}
+
+// slugTaken reports whether another owner of the kind holds slug, either as
+// its current slug or as a redirect. Owners are product IDs and category
+// names.
+func slugTaken(q sqlx.Queryer, kind, slug, owner string) (bool, error) {
+	live := `SELECT 1 FROM product.products WHERE slug = $1 AND id::text <> $2`
+	if kind == models.SlugKindCategory {
+		live = `SELECT 1 FROM product.categories WHERE slug = $1 AND name <> $2`
+	}
+	var taken bool
+	err := sqlx.Get(q, &taken,
+		`SELECT EXISTS (`+live+`)
+			OR EXISTS (SELECT 1 FROM product.slug_redirects WHERE kind = $3 AND slug = $1 AND target <> $2)`,
+		slug, owner, kind)
+	return taken, err
+}
+
+// uniqueSlug derives a free slug from name, appending -2, -3 and so on when
+// it is taken.
+func uniqueSlug(q sqlx.Queryer, kind, owner, name string) (string, error) {
+	base := slugify(name)
+	if base == "" {
+		base = kind
+	}
+	for n := 1; n <= 100; n++ {
+		candidate := base
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken, err := slugTaken(q, kind, candidate, owner)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", errSlugTaken
+}
+
+// assignSlug returns the slug a product gets: the requested one if it is
+// free, else its current one, else one generated from name. It returns
+// errSlugTaken when the requested slug belongs to another product.
+func assignSlug(q sqlx.Queryer, owner string, requested, current *string, name string) (string, error) {
+	switch {
+	case requested != nil:
+		if current != nil && *requested == *current {
+			return *current, nil
+		}
+		taken, err := slugTaken(q, models.SlugKindProduct, *requested, owner)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", errSlugTaken
+		}
+		return *requested, nil
+	case current != nil:
+		return *current, nil
+	}
+	return uniqueSlug(q, models.SlugKindProduct, owner, name)
+}
+
+// moveSlug records owner's slug changing from from to to: the old slug
+// becomes a redirect, and any redirect of the owner's at the new slug is
+// dropped since the slug is live again.
+func moveSlug(tx *sqlx.Tx, kind, owner string, from *string, to string) error {
+	if from != nil && *from != to {
+		_, err := tx.Exec(
+			`INSERT INTO product.slug_redirects (kind, slug, target) VALUES ($1, $2, $3)
+			 ON CONFLICT (kind, slug) DO UPDATE SET target = EXCLUDED.target, created_at = NOW()`,
+			kind, *from, owner)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`DELETE FROM product.slug_redirects WHERE kind = $1 AND slug = $2 AND target = $3`, kind, to, owner)
+	return err
+}
+
+// ensureCategory creates the named category with a generated slug unless it
+// already exists.
+func ensureCategory(q sqlx.Ext, name *string) error {
+	if name == nil || *name == "" {
+		return nil
+	}
+	var exists bool
+	if err := sqlx.Get(q, &exists, `SELECT EXISTS (SELECT 1 FROM product.categories WHERE name = $1)`, *name); err != nil || exists {
+		return err
+	}
+	slug, err := uniqueSlug(q, models.SlugKindCategory, *name, *name)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`INSERT INTO product.categories (name, slug) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`, *name, slug)
+	return err
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ecommerce/product/models"
+	"github.com/jmoiron/sqlx"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type StockAlertHandler struct {
+	DB      *sqlx.DB
+	Channel *amqp.Channel
+}
+
+func NewStockAlertHandler(db *sqlx.DB, ch *amqp.Channel) *StockAlertHandler {
+	return &StockAlertHandler{DB: db, Channel: ch}
+}
+
+// LowStock lists active products whose available stock is below their reorder
+// threshold, furthest below first.
+func (h *StockAlertHandler) LowStock(w http.ResponseWriter, r *http.Request) {
+	var items []models.LowStockItem
+	err := h.DB.Select(&items,
+		`SELECT p.id, p.sku, p.name, p.stock, p.available, p.reorder_threshold,
+			a.id AS alert_id, a.created_at AS alerted_at
+		 FROM (
+			SELECT id, sku, name, `+stockExpr+` AS stock, reorder_threshold, `+availableExpr+` AS available
+			FROM product.products WHERE archived_at IS NULL AND reorder_threshold > 0
+		 ) p
+		 LEFT JOIN product.stock_alerts a ON a.product_id = p.id AND a.resolved_at IS NULL
+		 WHERE p.available < p.reorder_threshold
+		 ORDER BY p.available - p.reorder_threshold, p.name`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch low-stock products"})
+		return
+	}
+	if items == nil {
+		items = []models.LowStockItem{}
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// StartChecker periodically resolves alerts for replenished products and
+// raises alerts, publishing product.low_stock, for products that dropped
+// below their threshold. The open-alert unique index keeps replicas from
+// raising the same alert twice.
+func (h *StockAlertHandler) StartChecker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.check()
+	}
+}
+
+func (h *StockAlertHandler) check() {
+	_, err := h.DB.Exec(
+		`UPDATE product.stock_alerts a SET resolved_at = NOW()
+		 FROM product.products
+		 WHERE a.product_id = products.id AND a.resolved_at IS NULL
+			AND (products.archived_at IS NOT NULL OR ` + availableExpr + ` >= products.reorder_threshold)`)
+	if err != nil {
+		log.Printf("Failed to resolve stock alerts: %v", err)
+		return
+	}
+
+	var raised []models.LowStockEvent
+	err = h.DB.Select(&raised,
+		`WITH raised AS (
+			INSERT INTO product.stock_alerts (product_id, threshold, available)
+			SELECT id, reorder_threshold, `+availableExpr+` FROM product.products
+			WHERE archived_at IS NULL AND reorder_threshold > 0 AND `+availableExpr+` < reorder_threshold
+			ON CONFLICT (product_id) WHERE resolved_at IS NULL DO NOTHING
+			RETURNING id, product_id, threshold, available, created_at
+		 )
+		 SELECT raised.id, raised.product_id, p.sku, p.name, raised.available, raised.threshold, raised.created_at
+		 FROM raised JOIN product.products p ON p.id = raised.product_id`)
+	if err != nil {
+		log.Printf("Failed to raise stock alerts: %v", err)
+		return
+	}
+	for _, event := range raised {
+		log.Printf("Product %s is low on stock: %d available, threshold %d", event.ProductID, event.Available, event.Threshold)
+		publishEvent(h.Channel, models.EventProductLowStock, event)
+	}
+}
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var localeTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// TranslationHandler manages per-locale product names and descriptions. The
+// product row itself holds the text in Default; SUPPORTED_LOCALES lists the
+// other locales every product is expected to be translated into.
+type TranslationHandler struct {
+	DB        *sqlx.DB
+	Channel   *amqp.Channel
+	Default   string
+	Supported []string
+}
+
+func NewTranslationHandler(db *sqlx.DB, ch *amqp.Channel) *TranslationHandler {
+	h := &TranslationHandler{DB: db, Channel: ch, Default: "en"}
+	if locale, ok := canonicalLocale(os.Getenv("DEFAULT_LOCALE")); ok {
+		h.Default = locale
+	}
+	for _, tag := range strings.Split(os.Getenv("SUPPORTED_LOCALES"), ",") {
+		if locale, ok := canonicalLocale(strings.TrimSpace(tag)); ok && locale != h.Default {
+			h.Supported = append(h.Supported, locale)
+		}
+	}
+	return h
+}
+
+// requestLocales returns the locales to try, best first, from ?locale= or
+// else Accept-Language. Each tag is followed by its less specific parents
+// and the chain ends with the default locale, so fr-CA tries fr-CA, fr, en.
+// ok is false for a malformed ?locale=.
+func (h *TranslationHandler) requestLocales(r *http.Request) (locales []string, ok bool) {
+	var tags []string
+	if v := r.URL.Query().Get("locale"); v != "" {
+		locale, ok := canonicalLocale(v)
+		if !ok {
+			return nil, false
+		}
+		tags = []string{locale}
+	} else {
+		tags = acceptLanguages(r.Header.Get("Accept-Language"))
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		for parts := strings.Split(tag, "-"); len(parts) > 0; parts = parts[:len(parts)-1] {
+			locale := strings.Join(parts, "-")
+			if !seen[locale] {
+				seen[locale] = true
+				locales = append(locales, locale)
+			}
+		}
+	}
+	if !seen[h.Default] {
+		locales = append(locales, h.Default)
+	}
+	return locales, true
+}
+
+// Translate replaces products' names and descriptions with the first
+// translation found along locales and sets Locale to the one used.
+func (h *TranslationHandler) Translate(products []models.Product, locales []string) error {
+	for i := range products {
+		products[i].Locale = h.Default
+	}
+	if len(products) == 0 || locales[0] == h.Default {
+		return nil
+	}
+
+	ids := make([]string, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	var translations []models.Translation
+	err := h.DB.Select(&translations,
+		`SELECT DISTINCT ON (product_id) `+translationColumns+` FROM product.product_translations
+		 WHERE product_id = ANY($1) AND locale = ANY($2)
+		 ORDER BY product_id, array_position($2, locale::text)`,
+		pq.Array(ids), pq.Array(locales))
+	if err != nil {
+		return err
+	}
+	byProduct := make(map[string]models.Translation, len(translations))
+	for _, t := range translations {
+		byProduct[t.ProductID] = t
+	}
+	for i := range products {
+		t, ok := byProduct[products[i].ID]
+		if !ok {
+			continue
+		}
+		products[i].Name, products[i].Locale = t.Name, t.Locale
+		if t.Description != nil {
+			products[i].Description = *t.Description
+		}
+	}
+	return nil
+}
+
+const translationColumns = `product_id, locale, name, description, updated_at`
+
+// List returns the product's translations and the supported locales it is
+// missing.
+func (h *TranslationHandler) List(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var exists bool
+	if err := h.DB.Get(&exists, `SELECT EXISTS (SELECT 1 FROM product.products WHERE id = $1)`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		return
+	}
+
+	status := models.TranslationStatus{ProductID: id, DefaultLocale: h.Default, Translations: []models.Translation{}, Missing: []string{}}
+	err := h.DB.Select(&status.Translations,
+		`SELECT `+translationColumns+` FROM product.product_translations WHERE product_id = $1 ORDER BY locale`, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch translations"})
+		return
+	}
+	translated := map[string]bool{}
+	for _, t := range status.Translations {
+		translated[t.Locale] = true
+	}
+	for _, locale := range h.Supported {
+		if !translated[locale] {
+			status.Missing = append(status.Missing, locale)
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Set creates or replaces the product's translation into a locale.
+func (h *TranslationHandler) Set(w http.ResponseWriter, r *http.Request) {
+	locale, ok := canonicalLocale(chi.URLParam(r, "locale"))
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag such as fr or fr-CA"})
+		return
+	}
+	if locale == h.Default {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "update the product itself to change " + h.Default + " text"})
+		return
+	}
+	var req models.SetTranslationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+		return
+	}
+
+	h.change(w, r, func(tx *sqlx.Tx, id string) (interface{}, error) {
+		var t models.Translation
+		err := tx.QueryRowx(
+			`INSERT INTO product.product_translations (product_id, locale, name, description) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (product_id, locale) DO UPDATE
+				SET name = EXCLUDED.name, description = EXCLUDED.description, updated_at = NOW()
+			 RETURNING `+translationColumns,
+			id, locale, req.Name, req.Description,
+		).StructScan(&t)
+		return &t, err
+	})
+}
+
+// Delete removes the product's translation into a locale.
+func (h *TranslationHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	locale, _ := canonicalLocale(chi.URLParam(r, "locale"))
+	h.change(w, r, func(tx *sqlx.Tx, id string) (interface{}, error) {
+		result, err := tx.Exec(`DELETE FROM product.product_translations WHERE product_id = $1 AND locale = $2`, id, locale)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return map[string]string{"status": "deleted"}, nil
+	})
+}
+
+// change applies a translation write under the product's row lock and bumps
+// the product's version, so ETags of translated reads change with it.
+func (h *TranslationHandler) change(w http.ResponseWriter, r *http.Request, apply func(*sqlx.Tx, string) (interface{}, error)) {
+	id := chi.URLParam(r, "id")
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, id)
+	if !ok {
+		return
+	}
+	result, err := apply(tx, id)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "translation not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update translation"})
+		return
+	}
+	if _, err := tx.Exec(`UPDATE product.products SET version = version + 1, updated_at = NOW() WHERE id = $1`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update product"})
+		return
+	}
+	if err := recordRevision(tx, id, models.RevisionTranslated, actor(r)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record product history"})
+		return
+	}
+	after, err := loadProduct(tx, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	publishCatalogEvent(h.Channel, models.EventProductUpdated, before, after, actor(r))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Missing lists active products lacking a translation into any supported
+// locale, or into ?locale= only, newest first. The total is sent in
+// X-Total-Count.
+func (h *TranslationHandler) Missing(w http.ResponseWriter, r *http.Request) {
+	locales := h.Supported
+	if v := r.URL.Query().Get("locale"); v != "" {
+		locale, ok := canonicalLocale(v)
+		if !ok || locale == h.Default {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "locale must be a language tag other than " + h.Default})
+			return
+		}
+		locales = []string{locale}
+	}
+	limit, offset := 50, 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 200 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v >= 0 {
+		offset = v
+	}
+
+	const missing = `WITH m AS (
+			SELECT p.id AS product_id, p.name, p.created_at,
+				ARRAY(SELECT l FROM unnest($1::text[]) l WHERE NOT EXISTS (
+					SELECT 1 FROM product.product_translations t WHERE t.product_id = p.id AND t.locale = l
+				)) AS missing
+			FROM product.products p WHERE p.archived_at IS NULL
+		)`
+	var total int
+	if err := h.DB.Get(&total, missing+` SELECT COUNT(*) FROM m WHERE cardinality(missing) > 0`, pq.Array(locales)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch translation status"})
+		return
+	}
+	var products []models.MissingTranslations
+	err := h.DB.Select(&products,
+		missing+` SELECT product_id, name, missing FROM m WHERE cardinality(missing) > 0
+		 ORDER BY created_at DESC LIMIT $2 OFFSET $3`,
+		pq.Array(locales), limit, offset)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch translation status"})
+		return
+	}
+	if products == nil {
+		products = []models.MissingTranslations{}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, products)
+}
+
+// canonicalLocale normalizes a language tag's case: language lowercase,
+// script title case, region uppercase, as in zh-Hant-TW.
+func canonicalLocale(tag string) (string, bool) {
+	tag = strings.ReplaceAll(tag, "_", "-")
+	if !localeTag.MatchString(tag) {
+		return "", false
+	}
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), true
+}
+
+// acceptLanguages returns the tags in an Accept-Language header ordered by
+// quality, skipping wildcards, q=0 and malformed entries.
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var entries []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag, ok := canonicalLocale(strings.TrimSpace(fields[0]))
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			entries = append(entries, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+	tags := make([]string, len(entries))
+	for i, e := range entries {
+		tags[i] = e.tag
+	}
+	return tags
+}
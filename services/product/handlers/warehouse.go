@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ecommerce/product/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	warehouseColumns = `id, code, name, latitude, longitude, is_default, created_at`
+	transferColumns  = `id, product_id, from_warehouse_id, to_warehouse_id, quantity, note, actor, created_at`
+)
+
+// WarehouseHandler manages warehouses, per-warehouse stock levels, transfers
+// and fulfilment planning. Any change to a product's warehouse levels happens
+// under the product's row lock.
+type WarehouseHandler struct {
+	DB       *sqlx.DB
+	Channel  *amqp.Channel
+	Strategy FulfilmentStrategy
+}
+
+func NewWarehouseHandler(db *sqlx.DB, ch *amqp.Channel) *WarehouseHandler {
+	return &WarehouseHandler{DB: db, Channel: ch, Strategy: fulfilmentStrategyFromEnv()}
+}
+
+func (h *WarehouseHandler) List(w http.ResponseWriter, r *http.Request) {
+	var warehouses []models.Warehouse
+	if err := h.DB.Select(&warehouses, `SELECT `+warehouseColumns+` FROM product.warehouses ORDER BY code`); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch warehouses"})
+		return
+	}
+	if warehouses == nil {
+		warehouses = []models.Warehouse{}
+	}
+	writeJSON(w, http.StatusOK, warehouses)
+}
+
+func (h *WarehouseHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateWarehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	if req.Code == "" || req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "code and name are required"})
+		return
+	}
+	if !validCoordinates(req.Latitude, req.Longitude) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "latitude and longitude must be given together and be in range"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.IsDefault {
+		if _, err := tx.Exec(`UPDATE product.warehouses SET is_default = false WHERE is_default`); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create warehouse"})
+			return
+		}
+	}
+	var warehouse models.Warehouse
+	err = tx.QueryRowx(
+		`INSERT INTO product.warehouses (code, name, latitude, longitude, is_default) VALUES ($1, $2, $3, $4, $5)
+		 RETURNING `+warehouseColumns,
+		req.Code, req.Name, req.Latitude, req.Longitude, req.IsDefault,
+	).StructScan(&warehouse)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "warehouse code already exists"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create warehouse"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, warehouse)
+}
+
+// Update changes a warehouse's details. Making a warehouse the default moves
+// the flag from the previous one; there is always exactly one default.
+func (h *WarehouseHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "warehouseID")
+	var req models.UpdateWarehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.IsDefault != nil && !*req.IsDefault {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "make another warehouse the default instead"})
+		return
+	}
+	if !validCoordinates(req.Latitude, req.Longitude) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "latitude and longitude must be given together and be in range"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.IsDefault != nil {
+		if _, err := tx.Exec(`UPDATE product.warehouses SET is_default = false WHERE is_default AND id <> $1`, id); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update warehouse"})
+			return
+		}
+	}
+	var warehouse models.Warehouse
+	err = tx.QueryRowx(
+		`UPDATE product.warehouses SET
+			name = COALESCE($1, name),
+			latitude = COALESCE($2, latitude),
+			longitude = COALESCE($3, longitude),
+			is_default = COALESCE($4, is_default)
+		 WHERE id = $5 RETURNING `+warehouseColumns,
+		req.Name, req.Latitude, req.Longitude, req.IsDefault, id,
+	).StructScan(&warehouse)
+	if err == sql.ErrNoRows {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "warehouse not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update warehouse"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	writeJSON(w, http.StatusOK, warehouse)
+}
+
+// ListStock returns the product's stock level in every warehouse holding it.
+func (h *WarehouseHandler) ListStock(w http.ResponseWriter, r *http.Request) {
+	var levels []models.StockLevel
+	err := h.DB.Select(&levels,
+		`SELECT ws.warehouse_id, w.code AS warehouse_code, ws.product_id, ws.quantity, ws.updated_at
+		 FROM product.warehouse_stock ws JOIN product.warehouses w ON w.id = ws.warehouse_id
+		 WHERE ws.product_id = $1 ORDER BY w.code`,
+		chi.URLParam(r, "id"),
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch stock levels"})
+		return
+	}
+	if levels == nil {
+		levels = []models.StockLevel{}
+	}
+	writeJSON(w, http.StatusOK, levels)
+}
+
+// SetStock sets the product's level in one warehouse, recording the
+// difference as a manual_count ledger entry, and updates the product's total
+// stock to match.
+func (h *WarehouseHandler) SetStock(w http.ResponseWriter, r *http.Request) {
+	productID := chi.URLParam(r, "id")
+	warehouseID := chi.URLParam(r, "warehouseID")
+	var req models.SetStockLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Quantity < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "non-negative quantity is required"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := lockProduct(w, tx, productID)
+	if !ok {
+		return
+	}
+	var current int
+	err = tx.Get(&current,
+		`SELECT COALESCE((SELECT quantity FROM product.warehouse_stock WHERE warehouse_id = $1 AND product_id = $2), 0)`,
+		warehouseID, productID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "22P02" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errUnknownWarehouse.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set stock level"})
+		return
+	}
+	_, err = recordMovement(tx, models.LedgerEntry{
+		ProductID: productID, WarehouseID: warehouseID, Delta: req.Quantity - current,
+		Reason: models.LedgerManualCount, Actor: actor(r), Reference: req.Reference, Note: req.Note,
+	})
+	if err != nil {
+		writeMovementError(w, err)
+		return
+	}
+	after, err := syncProductStock(tx, productID, actor(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set stock level"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	if after.Stock != before.Stock {
+		publishCatalogEvent(h.Channel, models.EventProductStockChanged, before, after, actor(r))
+	}
+	h.ListStock(w, r)
+}
+
+// Transfer moves stock between two warehouses, writing a transfer ledger
+// entry on each side. The product's total stock is unchanged.
+func (h *WarehouseHandler) Transfer(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.ProductID == "" || req.FromWarehouseID == "" || req.ToWarehouseID == "" || req.Quantity <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "product_id, both warehouses and a positive quantity are required"})
+		return
+	}
+	if req.FromWarehouseID == req.ToWarehouseID {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "source and destination warehouses must differ"})
+		return
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, ok := lockProduct(w, tx, req.ProductID); !ok {
+		return
+	}
+
+	var transfer models.StockTransfer
+	err = tx.QueryRowx(
+		`INSERT INTO product.stock_transfers (product_id, from_warehouse_id, to_warehouse_id, quantity, note, actor)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+transferColumns,
+		req.ProductID, req.FromWarehouseID, req.ToWarehouseID, req.Quantity, req.Note, actor(r),
+	).StructScan(&transfer)
+	if pqErr, ok := err.(*pq.Error); ok && (pqErr.Code == "23503" || pqErr.Code == "22P02") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errUnknownWarehouse.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record transfer"})
+		return
+	}
+	for _, side := range []struct {
+		warehouseID string
+		delta       int
+	}{{req.FromWarehouseID, -req.Quantity}, {req.ToWarehouseID, req.Quantity}} {
+		_, err := recordMovement(tx, models.LedgerEntry{
+			ProductID: req.ProductID, WarehouseID: side.warehouseID, Delta: side.delta,
+			Reason: models.LedgerTransfer, Actor: actor(r), Reference: "transfer:" + transfer.ID, Note: req.Note,
+		})
+		if err == errStockBelowZero {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "source warehouse does not hold enough stock"})
+			return
+		}
+		if err != nil {
+			writeMovementError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to commit transaction"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, transfer)
+}
+
+// ListTransfers returns transfers, newest first, optionally filtered by
+// ?product_id= and ?warehouse_id= (either side).
+func (h *WarehouseHandler) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	var productID, warehouseID *string
+	if v := r.URL.Query().Get("product_id"); v != "" {
+		productID = &v
+	}
+	if v := r.URL.Query().Get("warehouse_id"); v != "" {
+		warehouseID = &v
+	}
+	var transfers []models.StockTransfer
+	err := h.DB.Select(&transfers,
+		`SELECT `+transferColumns+` FROM product.stock_transfers
+		 WHERE ($1::uuid IS NULL OR product_id = $1)
+			AND ($2::uuid IS NULL OR from_warehouse_id = $2 OR to_warehouse_id = $2)
+		 ORDER BY created_at DESC LIMIT 200`,
+		productID, warehouseID,
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch transfers"})
+		return
+	}
+	if transfers == nil {
+		transfers = []models.StockTransfer{}
+	}
+	writeJSON(w, http.StatusOK, transfers)
+}
+
+// Plan previews which warehouses would ship the given lines, without moving
+// any stock. ?strategy= or the body's strategy overrides the default.
+func (h *WarehouseHandler) Plan(w http.ResponseWriter, r *http.Request) {
+	var req models.FulfilmentPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "at least one item is required"})
+		return
+	}
+	strategy := h.Strategy
+	if req.Strategy != "" {
+		var ok bool
+		if strategy, ok = fulfilmentStrategy(req.Strategy); !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "strategy must be nearest or most_stock"})
+			return
+		}
+	}
+	for _, item := range req.Items {
+		if item.ProductID == "" || item.Quantity <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "each item needs a product_id and positive quantity"})
+			return
+		}
+	}
+	items, err := expandBundles(h.DB, req.Items)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to expand bundles"})
+		return
+	}
+	productIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	candidates, err := loadCandidates(h.DB, productIDs, req.Destination)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch warehouse stock"})
+		return
+	}
+	plan, err := allocate(items, candidates, strategy)
+	if err == errInsufficientWarehouseStock {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to plan fulfilment"})
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// Allocations returns the warehouses an order was shipped from.
+func (h *WarehouseHandler) Allocations(w http.ResponseWriter, r *http.Request) {
+	var allocations []models.Allocation
+	err := h.DB.Select(&allocations,
+		`SELECT order_id, product_id, warehouse_id, quantity, strategy, created_at
+		 FROM product.fulfilment_allocations WHERE order_id = $1 ORDER BY product_id, warehouse_id`,
+		chi.URLParam(r, "orderID"),
+	)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch allocations"})
+		return
+	}
+	if len(allocations) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no allocations found for order"})
+		return
+	}
+	writeJSON(w, http.StatusOK, allocations)
+}
+
+// lockProduct takes the product's row lock for the rest of the transaction
+// and returns its snapshot, or writes the error response and returns false.
+func lockProduct(w http.ResponseWriter, tx *sqlx.Tx, id string) (*models.Product, bool) {
+	if _, err := tx.Exec(`SELECT 1 FROM product.products WHERE id = $1 FOR UPDATE`, id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return nil, false
+	}
+	product, err := loadProduct(tx, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch product"})
+		return nil, false
+	}
+	if product == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "product not found"})
+		return nil, false
+	}
+	return product, true
+}
+
+func validCoordinates(lat, lon *float64) bool {
+	if (lat == nil) != (lon == nil) {
+		return false
+	}
+	return lat == nil || (*lat >= -90 && *lat <= 90 && *lon >= -180 && *lon <= 180)
+}
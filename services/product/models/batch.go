@@ -0,0 +1,16 @@
+package models
+
+// BatchRequest asks for many products at once. Archived products count as
+// missing unless IncludeArchived is set.
+type BatchRequest struct {
+	IDs             []string `json:"ids"`
+	IncludeArchived bool     `json:"include_archived"`
+}
+
+// BatchResponse holds the products found, keyed by ID, and the requested IDs
+// that do not exist, are not visible or cannot be priced in the requested
+// currency.
+type BatchResponse struct {
+	Products map[string]Product `json:"products"`
+	Missing  []string           `json:"missing"`
+}
@@ -0,0 +1,40 @@
+package models
+
+// BundleComponent is one product in a bundle, with how many of it one bundle
+// contains.
+type BundleComponent struct {
+	ComponentID string  `json:"component_id" db:"component_id"`
+	Name        string  `json:"name" db:"name"`
+	Quantity    int     `json:"quantity" db:"quantity"`
+	Price       float64 `json:"price" db:"price"`
+	Available   int     `json:"available" db:"available"`
+}
+
+// Bundle is a product sold as a set of other products at its own price.
+// Savings is what buying the components separately would cost extra.
+type Bundle struct {
+	BundleID        string            `json:"bundle_id"`
+	Price           float64           `json:"price"`
+	ComponentsPrice float64           `json:"components_price"`
+	Savings         float64           `json:"savings"`
+	Available       int               `json:"available"`
+	Components      []BundleComponent `json:"components"`
+}
+
+type SetBundleRequest struct {
+	Components []ReservationItem `json:"components"`
+}
+
+// ExpandItemsRequest lists order lines to expand into the component lines
+// that are actually picked and shipped.
+type ExpandItemsRequest struct {
+	Items []ReservationItem `json:"items"`
+}
+
+// ExpandedLine is an order line with the component lines shipped for it,
+// already multiplied by the line's quantity. Plain products have none.
+type ExpandedLine struct {
+	ProductID  string            `json:"product_id"`
+	Quantity   int               `json:"quantity"`
+	Components []ReservationItem `json:"components"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ProductPrice is a per-currency price list entry. It replaces FX conversion
+// of the product's regular price for that currency.
+type ProductPrice struct {
+	ProductID string    `json:"product_id" db:"product_id"`
+	Currency  string    `json:"currency" db:"currency"`
+	Price     float64   `json:"price" db:"price"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SetProductPriceRequest struct {
+	Price float64 `json:"price"`
+}
+
+// ExchangeRate is the number of units of Currency per one unit of the base
+// currency.
+type ExchangeRate struct {
+	Currency  string    `json:"currency" db:"currency"`
+	Rate      float64   `json:"rate" db:"rate"`
+	Source    string    `json:"source" db:"source"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Routing keys for events on the catalog exchange.
+const (
+	EventProductCreated      = "product.created"
+	EventProductUpdated      = "product.updated"
+	EventProductDeleted      = "product.deleted"
+	EventProductStockChanged = "product.stock_changed"
+	EventProductPriceChanged = "product.price_changed"
+	EventProductLowStock     = "product.low_stock"
+	EventProductPublished    = "product.published"
+	EventProductUnpublished  = "product.unpublished"
+)
+
+// CatalogEvent describes a product mutation with full snapshots of the
+// product before and after it. Before is nil for product.created. Snapshot
+// prices are always in the base currency.
+type CatalogEvent struct {
+	Type       string    `json:"type"`
+	ProductID  string    `json:"product_id"`
+	Before     *Product  `json:"before,omitempty"`
+	After      *Product  `json:"after"`
+	Actor      string    `json:"actor"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
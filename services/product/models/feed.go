@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+const FormatXML = "xml"
+
+// Google Merchant availability values.
+const (
+	FeedInStock    = "in_stock"
+	FeedOutOfStock = "out_of_stock"
+)
+
+// FeedItem is one product in a merchant feed, using Google Merchant field
+// names. In XML feeds the fields live in the g: namespace; CSV feeds use
+// FeedColumns as their header row.
+type FeedItem struct {
+	XMLName              xml.Name `xml:"item"`
+	ID                   string   `xml:"g:id"`
+	Title                string   `xml:"g:title"`
+	Description          string   `xml:"g:description"`
+	Link                 string   `xml:"g:link"`
+	ImageLink            string   `xml:"g:image_link,omitempty"`
+	AdditionalImageLinks []string `xml:"g:additional_image_link"`
+	Availability         string   `xml:"g:availability"`
+	Price                string   `xml:"g:price"`
+	SalePrice            string   `xml:"g:sale_price,omitempty"`
+	Brand                string   `xml:"g:brand,omitempty"`
+	MPN                  string   `xml:"g:mpn,omitempty"`
+	IdentifierExists     string   `xml:"g:identifier_exists,omitempty"`
+	ProductType          string   `xml:"g:product_type,omitempty"`
+	Condition            string   `xml:"g:condition"`
+}
+
+var FeedColumns = []string{
+	"id", "title", "description", "link", "image_link", "additional_image_link", "availability",
+	"price", "sale_price", "brand", "mpn", "identifier_exists", "product_type", "condition",
+}
+
+// CSVRecord returns the item's fields in FeedColumns order. Additional image
+// links are comma-separated, as Google Merchant expects.
+func (i FeedItem) CSVRecord() []string {
+	return []string{
+		i.ID, i.Title, i.Description, i.Link, i.ImageLink, strings.Join(i.AdditionalImageLinks, ","), i.Availability,
+		i.Price, i.SalePrice, i.Brand, i.MPN, i.IdentifierExists, i.ProductType, i.Condition,
+	}
+}
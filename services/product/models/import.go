@@ -0,0 +1,83 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+type ImportJob struct {
+	ID            string          `json:"id" db:"id"`
+	Format        string          `json:"format" db:"format"`
+	Status        string          `json:"status" db:"status"`
+	TotalRows     int             `json:"total_rows" db:"total_rows"`
+	ProcessedRows int             `json:"processed_rows" db:"processed_rows"`
+	CreatedRows   int             `json:"created_rows" db:"created_rows"`
+	UpdatedRows   int             `json:"updated_rows" db:"updated_rows"`
+	FailedRows    int             `json:"failed_rows" db:"failed_rows"`
+	Errors        ImportRowErrors `json:"errors" db:"errors"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	CompletedAt   *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// ImportRow is one product record in an import or export file. CSV files use
+// the JSON field names as their header row. Rows with an ID update that
+// product; rows without one are matched on SKU, creating the product if no
+// product has it. Description, Category, Type, Attributes, Images and Stock
+// are left unchanged when absent from the row; in CSV files, attributes are a JSON object and
+// images are separated by spaces. Status only applies to products the import
+// creates, which are drafts unless it says published or unpublished; existing
+// products keep their status, which changes through publishing.
+type ImportRow struct {
+	ID          string          `json:"id,omitempty"`
+	SKU         string          `json:"sku"`
+	Name        string          `json:"name"`
+	Description *string         `json:"description"`
+	Category    *string         `json:"category"`
+	Type        *string         `json:"type"`
+	Attributes  json.RawMessage `json:"attributes,omitempty"`
+	Images      []string        `json:"images"`
+	Price       float64         `json:"price"`
+	Stock       *int            `json:"stock"`
+	Status      string          `json:"status,omitempty"`
+}
+
+// ImportColumns is the CSV header used for both import and export.
+var ImportColumns = []string{"id", "sku", "name", "description", "category", "type", "attributes", "images", "price", "stock", "status"}
+
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportRowErrors is stored as a JSONB array on the import job.
+type ImportRowErrors []ImportRowError
+
+func (e ImportRowErrors) Value() (driver.Value, error) {
+	if e == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(e)
+}
+
+func (e *ImportRowErrors) Scan(src interface{}) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.New("import errors: expected []byte")
+	}
+	return json.Unmarshal(b, e)
+}
@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Ledger reason codes. Transfers write a pair of entries, one per warehouse;
+// an opening balance records stock that predates the ledger.
+const (
+	LedgerReceipt        = "receipt"
+	LedgerSale           = "sale"
+	LedgerReturn         = "return"
+	LedgerDamage         = "damage"
+	LedgerManualCount    = "manual_count"
+	LedgerTransfer       = "transfer"
+	LedgerOpeningBalance = "opening_balance"
+)
+
+// LedgerEntry is one append-only stock movement in a warehouse.
+// QuantityAfter is the warehouse level right after it.
+type LedgerEntry struct {
+	ID            int64     `json:"id" db:"id"`
+	ProductID     string    `json:"product_id" db:"product_id"`
+	WarehouseID   string    `json:"warehouse_id" db:"warehouse_id"`
+	Delta         int       `json:"delta" db:"delta"`
+	QuantityAfter int       `json:"quantity_after" db:"quantity_after"`
+	Reason        string    `json:"reason" db:"reason"`
+	Actor         string    `json:"actor" db:"actor"`
+	Reference     string    `json:"reference" db:"reference"`
+	Note          string    `json:"note" db:"note"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// AdjustStockRequest records a movement for a product. WarehouseID defaults
+// to the default warehouse. Receipts and returns must be positive, sales and
+// damage negative.
+type AdjustStockRequest struct {
+	WarehouseID string `json:"warehouse_id,omitempty"`
+	Delta       int    `json:"delta"`
+	Reason      string `json:"reason"`
+	Reference   string `json:"reference"`
+	Note        string `json:"note"`
+}
+
+// CycleCount records a physical count of a product in a warehouse against
+// the level the system expected.
+type CycleCount struct {
+	ID          int64     `json:"id" db:"id"`
+	ProductID   string    `json:"product_id" db:"product_id"`
+	WarehouseID string    `json:"warehouse_id" db:"warehouse_id"`
+	Expected    int       `json:"expected" db:"expected"`
+	Counted     int       `json:"counted" db:"counted"`
+	Discrepancy int       `json:"discrepancy" db:"discrepancy"`
+	Actor       string    `json:"actor" db:"actor"`
+	Reference   string    `json:"reference" db:"reference"`
+	Note        string    `json:"note" db:"note"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type CycleCountRequest struct {
+	WarehouseID string `json:"warehouse_id,omitempty"`
+	Counted     int    `json:"counted"`
+	Reference   string `json:"reference"`
+	Note        string `json:"note"`
+}
+
+// ReconciliationIssue is a stock figure that disagrees with the ledger: a
+// warehouse level (WarehouseID set) or a product's total stock.
+type ReconciliationIssue struct {
+	ProductID   string  `json:"product_id" db:"product_id"`
+	WarehouseID *string `json:"warehouse_id,omitempty" db:"warehouse_id"`
+	Recorded    int     `json:"recorded" db:"recorded"`
+	Ledger      int     `json:"ledger" db:"ledger"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+const (
+	// ScheduleKindPrice permanently changes the regular price at StartsAt.
+	ScheduleKindPrice = "price"
+	// ScheduleKindSale overrides the price between StartsAt and EndsAt.
+	ScheduleKindSale = "sale"
+)
+
+const (
+	PriceReasonCreated     = "created"
+	PriceReasonUpdated     = "updated"
+	PriceReasonImported    = "imported"
+	PriceReasonScheduled   = "scheduled"
+	PriceReasonSaleStarted = "sale_started"
+	PriceReasonSaleEnded   = "sale_ended"
+)
+
+type PriceSchedule struct {
+	ID             string     `json:"id" db:"id"`
+	ProductID      string     `json:"product_id" db:"product_id"`
+	Kind           string     `json:"kind" db:"kind"`
+	Price          float64    `json:"price" db:"price"`
+	CompareAtPrice *float64   `json:"compare_at_price,omitempty" db:"compare_at_price"`
+	StartsAt       time.Time  `json:"starts_at" db:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at,omitempty" db:"ends_at"`
+	AppliedAt      *time.Time `json:"applied_at,omitempty" db:"applied_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty" db:"started_at"`
+	EndedAt        *time.Time `json:"ended_at,omitempty" db:"ended_at"`
+	CancelledAt    *time.Time `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	CreatedBy      string     `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+type CreatePriceScheduleRequest struct {
+	Kind           string     `json:"kind"`
+	Price          float64    `json:"price"`
+	CompareAtPrice *float64   `json:"compare_at_price,omitempty"`
+	StartsAt       *time.Time `json:"starts_at,omitempty"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"`
+}
+
+type PriceHistoryEntry struct {
+	ID             int64     `json:"id" db:"id"`
+	ProductID      string    `json:"product_id" db:"product_id"`
+	Price          float64   `json:"price" db:"price"`
+	CompareAtPrice *float64  `json:"compare_at_price,omitempty" db:"compare_at_price"`
+	Reason         string    `json:"reason" db:"reason"`
+	Actor          string    `json:"actor" db:"actor"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// PriceChangedEvent is published on the catalog exchange whenever a
+// scheduled price takes effect or a sale starts or ends.
+type PriceChangedEvent struct {
+	ProductID      string    `json:"product_id"`
+	ScheduleID     string    `json:"schedule_id"`
+	Reason         string    `json:"reason"`
+	Price          float64   `json:"price"`
+	RegularPrice   float64   `json:"regular_price"`
+	CompareAtPrice *float64  `json:"compare_at_price,omitempty"`
+	ChangedAt      time.Time `json:"changed_at"`
+}
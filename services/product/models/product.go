@@ -1,27 +1,111 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
 
+	"github.com/lib/pq"
+)
+
+// Product.Stock is the total across all warehouses and Available is that
+// total minus units held by reservations; per-warehouse levels are served
+// separately. Name and Description are in Locale, falling back to the
+// untranslated text.
 type Product struct {
-	ID          string    `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	Price       float64   `json:"price" db:"price"`
-	Stock       int       `json:"stock" db:"stock"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID               string          `json:"id" db:"id"`
+	SKU              *string         `json:"sku" db:"sku"`
+	Name             string          `json:"name" db:"name"`
+	Slug             *string         `json:"slug" db:"slug"`
+	Description      string          `json:"description" db:"description"`
+	Category         *string         `json:"category" db:"category"`
+	Type             *string         `json:"type" db:"type_id"`
+	Attributes       json.RawMessage `json:"attributes" db:"attributes"`
+	Images           pq.StringArray  `json:"images" db:"images"`
+	Price            float64         `json:"price" db:"price"`
+	RegularPrice     float64         `json:"regular_price" db:"regular_price"`
+	CompareAtPrice   *float64        `json:"compare_at_price,omitempty" db:"compare_at_price"`
+	Currency         string          `json:"currency,omitempty" db:"-"`
+	Locale           string          `json:"locale,omitempty" db:"-"`
+	Stock            int             `json:"stock" db:"stock"`
+	Available        int             `json:"available" db:"available"`
+	ReorderThreshold int             `json:"reorder_threshold" db:"reorder_threshold"`
+	IsBundle         bool            `json:"is_bundle" db:"is_bundle"`
+	Status           string          `json:"status" db:"status"`
+	PublishAt        *time.Time      `json:"publish_at,omitempty" db:"publish_at"`
+	PublishedAt      *time.Time      `json:"published_at,omitempty" db:"published_at"`
+	Version          int             `json:"version" db:"version"`
+	RatingAverage    *float64        `json:"rating_average" db:"rating_average"`
+	RatingCount      int             `json:"rating_count" db:"rating_count"`
+	ArchivedAt       *time.Time      `json:"archived_at,omitempty" db:"archived_at"`
+	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at" db:"updated_at"`
 }
 
 type CreateProductRequest struct {
-	Name        string  `json:"name"`
+	SKU  *string `json:"sku,omitempty"`
+	Name string  `json:"name"`
+	// Slug is generated from Name when empty.
+	Slug        string  `json:"slug,omitempty"`
 	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Stock       int     `json:"stock"`
+	Category    *string `json:"category,omitempty"`
+	// Type names a product type whose schema Attributes must satisfy.
+	Type       *string         `json:"type,omitempty"`
+	Attributes json.RawMessage `json:"attributes,omitempty"`
+	// Images are absolute image URLs, the first being the main image.
+	Images []string `json:"images,omitempty"`
+	Price  float64  `json:"price"`
+	Stock  int      `json:"stock"`
+	// ReorderThreshold raises a low-stock alert once available stock drops
+	// below it. Zero disables alerting.
+	ReorderThreshold int `json:"reorder_threshold"`
+	// Status is draft unless set to published. A PublishAt in the future
+	// schedules the product instead.
+	Status    string     `json:"status,omitempty"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
 }
 
 type UpdateProductRequest struct {
-	Name        *string  `json:"name,omitempty"`
-	Description *string  `json:"description,omitempty"`
-	Price       *float64 `json:"price,omitempty"`
-	Stock       *int     `json:"stock,omitempty"`
+	SKU  *string `json:"sku,omitempty"`
+	Name *string `json:"name,omitempty"`
+	// Changing Slug keeps the old one as a redirect.
+	Slug        *string `json:"slug,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Category    *string `json:"category,omitempty"`
+	// An empty Type removes the product's type, which requires clearing
+	// Attributes too.
+	Type       *string         `json:"type,omitempty"`
+	Attributes json.RawMessage `json:"attributes,omitempty"`
+	// An empty Images list removes all images.
+	Images           []string `json:"images,omitempty"`
+	Price            *float64 `json:"price,omitempty"`
+	Stock            *int     `json:"stock,omitempty"`
+	ReorderThreshold *int     `json:"reorder_threshold,omitempty"`
+}
+
+const (
+	RevisionCreated     = "created"
+	RevisionUpdated     = "updated"
+	RevisionArchived    = "archived"
+	RevisionRestored    = "restored"
+	RevisionImported    = "imported"
+	RevisionStock       = "stock_committed"
+	RevisionAdjusted    = "stock_adjusted"
+	RevisionBundle      = "bundle_changed"
+	RevisionScheduled   = "scheduled"
+	RevisionPublished   = "published"
+	RevisionUnpublished = "unpublished"
+	RevisionTranslated  = "translated"
+)
+
+// ProductRevision is an append-only record of one product version. Snapshot
+// holds the full product row as it was after the change.
+type ProductRevision struct {
+	ID            int64           `json:"id" db:"id"`
+	ProductID     string          `json:"product_id" db:"product_id"`
+	Version       int             `json:"version" db:"version"`
+	Action        string          `json:"action" db:"action"`
+	Actor         string          `json:"actor" db:"actor"`
+	ChangedFields pq.StringArray  `json:"changed_fields" db:"changed_fields"`
+	Snapshot      json.RawMessage `json:"snapshot" db:"snapshot"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
 }
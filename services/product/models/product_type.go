@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProductType defines the JSON Schema that a product's attributes must
+// satisfy, e.g. an author and page count for books.
+type ProductType struct {
+	ID        string          `json:"id" db:"id"`
+	Name      string          `json:"name" db:"name"`
+	Schema    json.RawMessage `json:"schema" db:"schema"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type CreateProductTypeRequest struct {
+	ID     string          `json:"id"`
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+type UpdateProductTypeRequest struct {
+	Name   *string         `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// FieldError reports why one field of a request was rejected. Field is a
+// dotted path such as attributes.pages.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Product statuses. Only published products appear in the public catalog. A
+// scheduled product counts as published once its PublishAt has passed.
+const (
+	StatusDraft       = "draft"
+	StatusScheduled   = "scheduled"
+	StatusPublished   = "published"
+	StatusUnpublished = "unpublished"
+)
+
+// PublishRequest publishes a product now, or schedules it when PublishAt is
+// in the future.
+type PublishRequest struct {
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+const (
+	RelatedBoughtTogether     = "bought_together"
+	RelatedCategoryBestseller = "category_bestseller"
+)
+
+// RelatedProduct is a recommendation for another product. Score is the
+// co-purchase similarity and is only set for bought_together results.
+type RelatedProduct struct {
+	Product
+	Score  *float64 `json:"score" db:"score"`
+	Source string   `json:"source" db:"source"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+type Reservation struct {
+	ID        string    `json:"id" db:"id"`
+	OrderID   string    `json:"order_id" db:"order_id"`
+	ProductID string    `json:"product_id" db:"product_id"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	Status    string    `json:"status" db:"status"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type ReservationItem struct {
+	ProductID string `json:"product_id" db:"product_id"`
+	Quantity  int    `json:"quantity" db:"quantity"`
+}
+
+type ReserveRequest struct {
+	OrderID    string            `json:"order_id"`
+	Items      []ReservationItem `json:"items"`
+	TTLSeconds int               `json:"ttl_seconds,omitempty"`
+}
+
+// InsufficientStockError is returned when a reservation asks for more units
+// than are currently available.
+type InsufficientStockError struct {
+	ProductID string
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for product %s: requested %d, available %d", e.ProductID, e.Requested, e.Available)
+}
+
+type OrderEvent struct {
+	OrderID string            `json:"order_id"`
+	UserID  string            `json:"user_id"`
+	Total   float64           `json:"total"`
+	Status  string            `json:"status"`
+	Items   []ReservationItem `json:"items"`
+}
+
+// EventReservationFailed is published on the orders exchange when an
+// order's stock cannot be reserved.
+const EventReservationFailed = "reservation.failed"
+
+// ReservationFailedEvent reports an order that could not be reserved. For
+// insufficient stock it names the first product that was short.
+type ReservationFailedEvent struct {
+	OrderID    string    `json:"order_id"`
+	Reason     string    `json:"reason"`
+	ProductID  string    `json:"product_id,omitempty"`
+	Requested  int       `json:"requested,omitempty"`
+	Available  int       `json:"available,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type PaymentStatusEvent struct {
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"`
+}
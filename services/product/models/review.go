@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+type Review struct {
+	ID           string    `json:"id" db:"id"`
+	ProductID    string    `json:"product_id" db:"product_id"`
+	UserID       string    `json:"user_id" db:"user_id"`
+	OrderID      string    `json:"order_id" db:"order_id"`
+	Rating       int       `json:"rating" db:"rating"`
+	Title        string    `json:"title" db:"title"`
+	Body         string    `json:"body" db:"body"`
+	Status       string    `json:"status" db:"status"`
+	HelpfulCount int       `json:"helpful_count" db:"helpful_count"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type CreateReviewRequest struct {
+	Rating int    `json:"rating"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+type ModerateReviewRequest struct {
+	Status string `json:"status"`
+}
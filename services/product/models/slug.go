@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Kinds of slug kept in the redirect history.
+const (
+	SlugKindProduct  = "product"
+	SlugKindCategory = "category"
+)
+
+// Category is a product category with its URL slug. Categories are created
+// the first time a product uses them.
+type Category struct {
+	Name         string    `json:"name" db:"name"`
+	Slug         string    `json:"slug" db:"slug"`
+	ProductCount int       `json:"product_count" db:"product_count"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SetSlugRequest struct {
+	Slug string `json:"slug"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// StockAlert is raised when a product's available stock drops below its
+// reorder threshold, and resolved once it is back at or above it. A product
+// has at most one open alert.
+type StockAlert struct {
+	ID         int64      `json:"id" db:"id"`
+	ProductID  string     `json:"product_id" db:"product_id"`
+	Threshold  int        `json:"threshold" db:"threshold"`
+	Available  int        `json:"available" db:"available"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+}
+
+// LowStockItem is a product currently below its reorder threshold. AlertID
+// and AlertedAt are empty until the checker has picked it up.
+type LowStockItem struct {
+	ProductID        string     `json:"product_id" db:"id"`
+	SKU              *string    `json:"sku" db:"sku"`
+	Name             string     `json:"name" db:"name"`
+	Stock            int        `json:"stock" db:"stock"`
+	Available        int        `json:"available" db:"available"`
+	ReorderThreshold int        `json:"reorder_threshold" db:"reorder_threshold"`
+	AlertID          *int64     `json:"alert_id" db:"alert_id"`
+	AlertedAt        *time.Time `json:"alerted_at" db:"alerted_at"`
+}
+
+// LowStockEvent is published as product.low_stock when an alert is raised.
+type LowStockEvent struct {
+	AlertID    int64     `json:"alert_id" db:"id"`
+	ProductID  string    `json:"product_id" db:"product_id"`
+	SKU        *string   `json:"sku" db:"sku"`
+	Name       string    `json:"name" db:"name"`
+	Available  int       `json:"available" db:"available"`
+	Threshold  int       `json:"threshold" db:"threshold"`
+	OccurredAt time.Time `json:"occurred_at" db:"created_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Translation is a product's name and description in one locale. A missing
+// Description falls back to the untranslated one.
+type Translation struct {
+	ProductID   string    `json:"product_id" db:"product_id"`
+	Locale      string    `json:"locale" db:"locale"`
+	Name        string    `json:"name" db:"name"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TranslationStatus lists a product's translations and the supported
+// locales it has none for.
+type TranslationStatus struct {
+	ProductID     string        `json:"product_id"`
+	DefaultLocale string        `json:"default_locale"`
+	Translations  []Translation `json:"translations"`
+	Missing       []string      `json:"missing"`
+}
+
+// MissingTranslations is a product lacking translations in some supported
+// locales.
+type MissingTranslations struct {
+	ProductID string         `json:"product_id" db:"product_id"`
+	Name      string         `json:"name" db:"name"`
+	Missing   pq.StringArray `json:"missing" db:"missing"`
+}
+
+type SetTranslationRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+}
@@ -0,0 +1,108 @@
+package models
+
+import "time"
+
+const (
+	FulfilmentNearest   = "nearest"
+	FulfilmentMostStock = "most_stock"
+)
+
+// Warehouse is a stock location. Product-level stock changes (create, update,
+// import) are applied to the default warehouse.
+type Warehouse struct {
+	ID        string    `json:"id" db:"id"`
+	Code      string    `json:"code" db:"code"`
+	Name      string    `json:"name" db:"name"`
+	Latitude  *float64  `json:"latitude" db:"latitude"`
+	Longitude *float64  `json:"longitude" db:"longitude"`
+	IsDefault bool      `json:"is_default" db:"is_default"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type CreateWarehouseRequest struct {
+	Code      string   `json:"code"`
+	Name      string   `json:"name"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	IsDefault bool     `json:"is_default"`
+}
+
+type UpdateWarehouseRequest struct {
+	Name      *string  `json:"name,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	IsDefault *bool    `json:"is_default,omitempty"`
+}
+
+// StockLevel is a product's stock in one warehouse. A product's Stock is the
+// sum of its levels.
+type StockLevel struct {
+	WarehouseID   string    `json:"warehouse_id" db:"warehouse_id"`
+	WarehouseCode string    `json:"warehouse_code" db:"warehouse_code"`
+	ProductID     string    `json:"product_id" db:"product_id"`
+	Quantity      int       `json:"quantity" db:"quantity"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type SetStockLevelRequest struct {
+	Quantity  int    `json:"quantity"`
+	Reference string `json:"reference"`
+	Note      string `json:"note"`
+}
+
+type StockTransfer struct {
+	ID              string    `json:"id" db:"id"`
+	ProductID       string    `json:"product_id" db:"product_id"`
+	FromWarehouseID string    `json:"from_warehouse_id" db:"from_warehouse_id"`
+	ToWarehouseID   string    `json:"to_warehouse_id" db:"to_warehouse_id"`
+	Quantity        int       `json:"quantity" db:"quantity"`
+	Note            string    `json:"note" db:"note"`
+	Actor           string    `json:"actor" db:"actor"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+type CreateTransferRequest struct {
+	ProductID       string `json:"product_id"`
+	FromWarehouseID string `json:"from_warehouse_id"`
+	ToWarehouseID   string `json:"to_warehouse_id"`
+	Quantity        int    `json:"quantity"`
+	Note            string `json:"note"`
+}
+
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// FulfilmentCandidate is a warehouse considered for an order, with its stock
+// of the order's products and its distance in km to the destination, if
+// both locations are known.
+type FulfilmentCandidate struct {
+	Warehouse
+	Stock    map[string]int
+	Distance *float64
+}
+
+// Allocation assigns part of an order line to a warehouse.
+type Allocation struct {
+	OrderID     string     `json:"order_id,omitempty" db:"order_id"`
+	ProductID   string     `json:"product_id" db:"product_id"`
+	WarehouseID string     `json:"warehouse_id" db:"warehouse_id"`
+	Quantity    int        `json:"quantity" db:"quantity"`
+	Strategy    string     `json:"strategy,omitempty" db:"strategy"`
+	CreatedAt   *time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+type FulfilmentPlanRequest struct {
+	Items       []ReservationItem `json:"items"`
+	Destination *GeoPoint         `json:"destination,omitempty"`
+	Strategy    string            `json:"strategy,omitempty"`
+}
+
+// FulfilmentPlan is the warehouse allocation for a set of order lines. Split
+// is set when no single warehouse could ship everything.
+type FulfilmentPlan struct {
+	Strategy    string       `json:"strategy"`
+	Split       bool         `json:"split"`
+	Allocations []Allocation `json:"allocations"`
+}
@@ -1,6 +1,21 @@
 window.api = (function() {
     const API_URL = '';
 
+    // authFetch sends the signed-in user's token, which is how the cart and
+    // order services know who is calling. A rejected token ends the session.
+    async function authFetch(url, options) {
+        options = options || {};
+        const token = localStorage.getItem('token');
+        if (token) {
+            options.headers = Object.assign({}, options.headers, { 'Authorization': 'Bearer ' + token });
+        }
+        const res = await fetch(url, options);
+        if (res.status === 401) {
+            window.dispatchEvent(new Event('session-expired'));
+        }
+        return res;
+    }
+
     async function register(email, password) {
         const res = await fetch(`${API_URL}/api/auth/register`, {
             method: 'POST',
@@ -28,61 +43,76 @@ window.api = (function() {
         return await res.json() || [];
     }
 
-    async function getCart(userId) {
-        const res = await fetch(`${API_URL}/api/cart/`, {
-            headers: { 'X-User-ID': userId }
-        });
+    async function getCart() {
+        const res = await authFetch(`${API_URL}/api/cart/`);
         return await res.json();
     }
 
-    async function addCartItem(userId, productId, quantity, price) {
-        const res = await fetch(`${API_URL}/api/cart/items`, {
+    async function addCartItem(productId, quantity) {
+        const res = await authFetch(`${API_URL}/api/cart/items`, {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json',
-                'X-User-ID': userId
+                'Content-Type': 'application/json'
             },
-            body: JSON.stringify({ product_id: productId, quantity: quantity, price: price })
+            body: JSON.stringify({ product_id: productId, quantity: quantity })
         });
         if (!res.ok) throw new Error('Failed to add to cart');
         return await res.json();
     }
 
-    async function removeCartItem(userId, itemId) {
-        const res = await fetch(`${API_URL}/api/cart/items/${itemId}`, {
-            method: 'DELETE',
-            headers: { 'X-User-ID': userId }
+    async function removeCartItem(itemId) {
+        const res = await authFetch(`${API_URL}/api/cart/items/${itemId}`, {
+            method: 'DELETE'
         });
         if (!res.ok) throw new Error('Failed to remove item');
         return res;
     }
 
-    async function clearCart(userId) {
-        const res = await fetch(`${API_URL}/api/cart/`, {
-            method: 'DELETE',
-            headers: { 'X-User-ID': userId }
+    async function clearCart() {
+        const res = await authFetch(`${API_URL}/api/cart/`, {
+            method: 'DELETE'
         });
         if (!res.ok) throw new Error('Failed to clear cart');
         return res;
     }
 
-    async function createOrder(userId, items) {
-        const res = await fetch(`${API_URL}/api/orders/`, {
+    async function createOrder() {
+        // The order service checks out the cart as priced
+        const res = await authFetch(`${API_URL}/api/orders/`, {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json',
-                'X-User-ID': userId
+                'Content-Type': 'application/json'
             },
-            body: JSON.stringify({ items: items })
+            body: JSON.stringify({})
         });
-        if (!res.ok) throw new Error('Failed to create order');
-        return await res.json();
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to create order');
+        return data;
+    }
+
+    async function applyCoupon(code) {
+        const res = await authFetch(`${API_URL}/api/cart/coupons`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({ code: code })
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data.error || 'Failed to apply coupon');
+        return data;
     }
 
-    async function getOrders(userId) {
-        const res = await fetch(`${API_URL}/api/orders/`, {
-            headers: { 'X-User-ID': userId }
+    async function removeCoupon(code) {
+        const res = await authFetch(`${API_URL}/api/cart/coupons/${encodeURIComponent(code)}`, {
+            method: 'DELETE'
         });
+        if (!res.ok) throw new Error('Failed to remove coupon');
+        return await res.json();
+    }
+
+    async function getOrders() {
+        const res = await authFetch(`${API_URL}/api/orders/`);
         return await res.json() || [];
     }
 
@@ -101,6 +131,8 @@ window.api = (function() {
         removeCartItem,
         clearCart,
         createOrder,
+        applyCoupon,
+        removeCoupon,
         getOrders,
         getPaymentStatus
     };
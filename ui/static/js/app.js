@@ -132,7 +132,7 @@ async function addToCart(productId) {
     var product = productsCache[productId];
 
     try {
-        await api.addCartItem(userId, productId, qty, product.price);
+        await api.addCartItem(productId, qty);
         ui.showToast('Added ' + product.name + ' to cart!', 'success');
         loadCart();
     } catch (err) {
@@ -143,7 +143,7 @@ async function addToCart(productId) {
 // Cart
 async function loadCart() {
     try {
-        var data = await api.getCart(userId);
+        var data = await api.getCart();
         var items = data.items || [];
         ui.updateCartBadge(items.length);
 
@@ -152,7 +152,7 @@ async function loadCart() {
             return;
         }
 
-        document.getElementById('cartContent').innerHTML = ui.renderCart(items, productsCache);
+        document.getElementById('cartContent').innerHTML = ui.renderCart(items, productsCache, data.totals, data.coupons);
     } catch (err) {
         console.error('Error loading cart:', err);
     }
@@ -160,7 +160,7 @@ async function loadCart() {
 
 async function removeFromCart(itemId) {
     try {
-        await api.removeCartItem(userId, itemId);
+        await api.removeCartItem(itemId);
         ui.showToast('Item removed from cart', 'success');
         loadCart();
     } catch (err) {
@@ -171,7 +171,7 @@ async function removeFromCart(itemId) {
 async function clearCart() {
     if (!confirm('Are you sure you want to empty your cart?')) return;
     try {
-        await api.clearCart(userId);
+        await api.clearCart();
         ui.showToast('Cart cleared', 'success');
         loadCart();
     } catch (err) {
@@ -179,16 +179,35 @@ async function clearCart() {
     }
 }
 
+async function applyCoupon() {
+    var code = document.getElementById('couponCode').value.trim();
+    if (!code) return;
+    try {
+        await api.applyCoupon(code);
+        ui.showToast('Coupon ' + code.toUpperCase() + ' applied', 'success');
+        loadCart();
+    } catch (err) {
+        ui.showToast(err.message, 'error');
+    }
+}
+
+async function removeCoupon(code) {
+    try {
+        await api.removeCoupon(code);
+        loadCart();
+    } catch (err) {
+        ui.showToast(err.message, 'error');
+    }
+}
+
 async function checkout(total) {
     if (!confirm('Confirm your order for $' + total.toFixed(2) + '?')) return;
 
     try {
-        var cartData = await api.getCart(userId);
-        var order = await api.createOrder(userId, cartData.items);
+        var order = await api.createOrder();
         ui.showToast('Order placed! ID: ' + order.id.slice(0, 8), 'success');
 
-        // Clear cart silently
-        await api.clearCart(userId);
+        // The order service empties the cart it checked out
         loadCart();
         setTimeout(function() { switchTab('orders'); }, 1000);
     } catch (err) {
@@ -199,7 +218,7 @@ async function checkout(total) {
 // Orders
 async function loadOrders() {
     try {
-        var orders = await api.getOrders(userId);
+        var orders = await api.getOrders();
 
         if (orders.length === 0) {
             document.getElementById('ordersList').innerHTML = ui.renderEmptyOrders();
@@ -229,6 +248,13 @@ async function checkPaymentStatus(orderId) {
     }
 }
 
+// Tokens expire; sign the user out rather than act as a guest
+window.addEventListener('session-expired', function() {
+    if (!token) return;
+    logout();
+    ui.showToast('Your session has expired, please sign in again', 'error');
+});
+
 // Initialize
 window.addEventListener('load', function() {
     if (token && userId) {
@@ -45,7 +45,8 @@ window.ui = (function() {
         const statusMap = {
             'pending': '⏳ Pending',
             'completed': '✅ Completed',
-            'failed': '❌ Failed'
+            'failed': '❌ Failed',
+            'out_of_stock': '🚫 Out of stock'
         };
         return statusMap[status.toLowerCase()] || status;
     }
@@ -112,10 +113,23 @@ window.ui = (function() {
         }).join('');
     }
 
-    function renderCart(items, productsCache) {
-        var subtotal = items.reduce(function(sum, item) { return sum + (item.price * item.quantity); }, 0);
-        var shipping = subtotal > 50 ? 0 : 5.99;
-        var total = subtotal + shipping;
+    function renderCart(items, productsCache, totals, coupons) {
+        // Totals come from the cart service's pricing engine
+        totals = totals || { subtotal: 0, total: 0, adjustments: [] };
+        var adjustmentRows = (totals.adjustments || []).map(function(a) {
+            var negative = a.type === 'discount' || a.type === 'shipping_discount';
+            var amount = (negative ? '-$' : '$') + a.amount.toFixed(2);
+            return '<div class="summary-row"><span>' + escapeHtml(a.description) + '</span><span>' + amount + '</span></div>';
+        }).join('');
+        if (totals.shipping === 0 && (totals.lines || []).length > 0) {
+            adjustmentRows += '<div class="summary-row"><span>Shipping</span><span>FREE</span></div>';
+        }
+        var total = totals.total;
+        var couponRows = (coupons || []).map(function(c) {
+            return '<div class="summary-row"><span>' + escapeHtml(c.code) +
+                (c.applied ? '' : ' <small>(' + escapeHtml(c.reason) + ')</small>') + '</span>' +
+                '<button class="cymbal-button-secondary cymbal-button-small" onclick="removeCoupon(\'' + escapeHtml(c.code) + '\')">Remove</button></div>';
+        }).join('');
 
         return '<div class="cart-layout">' +
             '<div class="cart-section">' +
@@ -147,8 +161,11 @@ window.ui = (function() {
             '</div>' +
             '<div class="cart-summary-section">' +
                 '<h3 style="margin-bottom: 24px;">Order Summary</h3>' +
-                '<div class="summary-row"><span>Subtotal</span><span>$' + subtotal.toFixed(2) + '</span></div>' +
-                '<div class="summary-row"><span>Shipping</span><span>' + (shipping === 0 ? 'FREE' : '$' + shipping.toFixed(2)) + '</span></div>' +
+                '<div class="summary-row"><span>Subtotal</span><span>$' + totals.subtotal.toFixed(2) + '</span></div>' +
+                adjustmentRows +
+                couponRows +
+                '<div class="summary-row"><input id="couponCode" placeholder="Coupon code" style="flex: 1; margin-right: 8px;">' +
+                    '<button class="cymbal-button-secondary cymbal-button-small" onclick="applyCoupon()">Apply</button></div>' +
                 '<div class="summary-row total"><span>Total</span><span>$' + total.toFixed(2) + '</span></div>' +
                 '<button class="cymbal-button-primary" onclick="checkout(' + total + ')" style="width: 100%; margin-top: 24px; padding: 14px;">Place Order</button>' +
                 '<p style="text-align: center; font-size: 13px; color: #605f64; margin-top: 16px;">🔒 Secure checkout</p>' +